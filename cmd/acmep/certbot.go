@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns01"
+)
+
+const certbotUsage = `usage: acmep certbot-hook auth|cleanup --server URL [flags]
+
+For use as certbot's --manual-auth-hook and --manual-cleanup-hook. The domain
+and validation are read from $CERTBOT_DOMAIN and $CERTBOT_VALIDATION.
+Credentials are read like for acmep client.
+
+flags:
+`
+
+func runCertbotHook(log *logrus.Logger, args []string) error {
+	fs := flag.NewFlagSet("certbot-hook", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), certbotUsage)
+		fs.PrintDefaults()
+	}
+	var flags clientFlags
+	flags.register(fs)
+
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("missing action")
+	}
+	action := args[0]
+	err := fs.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+
+	domain := os.Getenv("CERTBOT_DOMAIN")
+	value := os.Getenv("CERTBOT_VALIDATION")
+	if domain == "" || value == "" {
+		return errors.New("CERTBOT_DOMAIN and CERTBOT_VALIDATION must be set")
+	}
+	fqdn := certbotChallengeFQDN(domain)
+
+	c, err := flags.newClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch action {
+	case "auth":
+		log.Infof("presenting %s (%s challenges remaining)", fqdn, os.Getenv("CERTBOT_REMAINING_CHALLENGES"))
+		err = c.Present(ctx, fqdn, value)
+		if err != nil {
+			return err
+		}
+		return flags.waitForPropagation(ctx, log, fqdn, value)
+	case "cleanup":
+		log.Infof("cleaning up %s", fqdn)
+		return c.Cleanup(ctx, fqdn, value)
+	default:
+		fs.Usage()
+		return errors.Errorf("unknown action %q", action)
+	}
+}
+
+// certbotChallengeFQDN returns the _acme-challenge record name for the
+// domain certbot is validating. Wildcards are validated at the base domain.
+func certbotChallengeFQDN(domain string) string {
+	domain = strings.TrimPrefix(domain, "*.")
+	return dns01.TXTRecordName(dns01.ToFQDN(domain))
+}
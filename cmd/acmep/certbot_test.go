@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertbotChallengeFQDN(t *testing.T) {
+	testCases := []struct {
+		domain   string
+		expected string
+	}{
+		{domain: "domain.example", expected: "_acme-challenge.domain.example."},
+		{domain: "a.domain.example.", expected: "_acme-challenge.a.domain.example."},
+		{domain: "*.domain.example", expected: "_acme-challenge.domain.example."},
+		{domain: "*.a.domain.example", expected: "_acme-challenge.a.domain.example."},
+	}
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, certbotChallengeFQDN(test.domain), test.domain)
+	}
+}
+
+func TestRunCertbotHook(t *testing.T) {
+	clearClientEnv(t)
+	t.Setenv("ACMEP_TOKEN", "env-token")
+	url, requests := recordingServer(t)
+	log := quietLogger()
+
+	testCases := []struct {
+		args       []string
+		domain     string
+		validation string
+		path       string
+		fqdn       string
+		err        string
+	}{{
+		args: nil,
+		err:  "missing action",
+	}, {
+		args:       []string{"auth", "--server", url},
+		validation: "v",
+		err:        "CERTBOT_DOMAIN and CERTBOT_VALIDATION must be set",
+	}, {
+		args:   []string{"auth", "--server", url},
+		domain: "domain.example",
+		err:    "CERTBOT_DOMAIN and CERTBOT_VALIDATION must be set",
+	}, {
+		args:       []string{"deploy", "--server", url},
+		domain:     "domain.example",
+		validation: "v",
+		err:        `unknown action "deploy"`,
+	}, {
+		args:       []string{"auth", "--server", url},
+		domain:     "*.domain.example",
+		validation: "v",
+		path:       "/present",
+		fqdn:       "_acme-challenge.domain.example.",
+	}, {
+		args:       []string{"cleanup", "--server", url},
+		domain:     "a.domain.example",
+		validation: "v",
+		path:       "/cleanup",
+		fqdn:       "_acme-challenge.a.domain.example.",
+	}}
+	for _, test := range testCases {
+		t.Setenv("CERTBOT_DOMAIN", test.domain)
+		t.Setenv("CERTBOT_VALIDATION", test.validation)
+		err := runCertbotHook(log, test.args)
+		got := requests()
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, "%q", test.args)
+			assert.Empty(t, got, "%q", test.args)
+			continue
+		}
+		assert.NoError(t, err, "%q", test.args)
+		assert.Equal(t, []recordedRequest{{
+			Path:          test.path,
+			Authorization: "Bearer env-token",
+			Body:          map[string]string{"fqdn": test.fqdn, "value": test.validation},
+		}}, got, "%q", test.args)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/client"
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns01"
+)
+
+const clientUsage = `usage: acmep client present|cleanup --server URL --fqdn NAME --value VALUE [flags]
+
+Credentials are read from --credentials-file (username:password) or
+--token-file, or else from $ACMEP_USERNAME and $ACMEP_PASSWORD or $ACMEP_TOKEN.
+
+flags:
+`
+
+// clientFlags are the flags shared by the client subcommands.
+type clientFlags struct {
+	server          string
+	credentialsFile string
+	tokenFile       string
+	retries         int
+	lifetime        time.Duration
+	wait            bool
+	waitTimeout     time.Duration
+	resolvers       string
+}
+
+func (f *clientFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.server, "server", os.Getenv("ACMEP_SERVER"), "acmep server URL (default $ACMEP_SERVER)")
+	fs.StringVar(&f.credentialsFile, "credentials-file", "", "file containing username:password")
+	fs.StringVar(&f.tokenFile, "token-file", "", "file containing a bearer token")
+	fs.IntVar(&f.retries, "retries", client.DefaultRetries, "retries on transient errors")
+	fs.DurationVar(&f.lifetime, "lifetime", 0, "ask the server to clean up the record after this long")
+	fs.BoolVar(&f.wait, "wait", false, "after present, wait until the TXT record is served by all authoritative nameservers")
+	fs.DurationVar(&f.waitTimeout, "wait-timeout", 2*time.Minute, "how long to wait for the TXT record")
+	fs.StringVar(&f.resolvers, "resolvers", "", "comma separated resolvers for --wait, defaults to the system resolvers")
+}
+
+// newClient builds a client from the flags and the environment.
+func (f *clientFlags) newClient() (*client.Client, error) {
+	if f.server == "" {
+		return nil, errors.New("--server not set")
+	}
+	c := &client.Client{
+		Server:   f.server,
+		Lifetime: f.lifetime,
+		Retries:  f.retries,
+	}
+	if f.retries == 0 {
+		c.Retries = -1
+	}
+
+	switch {
+	case f.credentialsFile != "":
+		b, err := os.ReadFile(f.credentialsFile)
+		if err != nil {
+			return nil, errors.Annotate(err, "reading credentials")
+		}
+		username, password, ok := strings.Cut(strings.TrimSpace(string(b)), ":")
+		if !ok {
+			return nil, errors.Errorf("%s: expected username:password", f.credentialsFile)
+		}
+		c.Username, c.Password = username, password
+	case f.tokenFile != "":
+		b, err := os.ReadFile(f.tokenFile)
+		if err != nil {
+			return nil, errors.Annotate(err, "reading token")
+		}
+		c.Token = strings.TrimSpace(string(b))
+	case os.Getenv("ACMEP_TOKEN") != "":
+		c.Token = os.Getenv("ACMEP_TOKEN")
+	case os.Getenv("ACMEP_USERNAME") != "":
+		c.Username = os.Getenv("ACMEP_USERNAME")
+		c.Password = os.Getenv("ACMEP_PASSWORD")
+	default:
+		return nil, errors.New("no credentials configured")
+	}
+	return c, nil
+}
+
+// waitForPropagation waits for the TXT record if --wait was given.
+func (f *clientFlags) waitForPropagation(ctx context.Context, log *logrus.Logger, fqdn, value string) error {
+	if !f.wait {
+		return nil
+	}
+	var custom []string
+	if f.resolvers != "" {
+		custom = strings.Split(f.resolvers, ",")
+	}
+	log.Infof("waiting for %s", dns01.TXTRecordName(dns01.FQDNFromTXTRecordName(fqdn)))
+	ctx, cancel := context.WithTimeout(ctx, f.waitTimeout)
+	defer cancel()
+	return client.WaitForPropagation(ctx, fqdn, value, dns01.RecursiveNameservers(custom), 5*time.Second)
+}
+
+func runClient(log *logrus.Logger, args []string) error {
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), clientUsage)
+		fs.PrintDefaults()
+	}
+	var flags clientFlags
+	var fqdn, value string
+	flags.register(fs)
+	fs.StringVar(&fqdn, "fqdn", "", "domain to validate, or its _acme-challenge record name")
+	fs.StringVar(&value, "value", "", "TXT record value")
+
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("missing action")
+	}
+	action := args[0]
+	err := fs.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	if fqdn == "" || value == "" {
+		return errors.New("--fqdn and --value must be set")
+	}
+
+	c, err := flags.newClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch action {
+	case "present":
+		err = c.Present(ctx, fqdn, value)
+		if err != nil {
+			return err
+		}
+		return flags.waitForPropagation(ctx, log, fqdn, value)
+	case "cleanup":
+		return c.Cleanup(ctx, fqdn, value)
+	default:
+		fs.Usage()
+		return errors.Errorf("unknown action %q", action)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/client"
+)
+
+// recordedRequest is a request received by a recordingServer.
+type recordedRequest struct {
+	Path          string
+	Authorization string
+	Body          map[string]string
+}
+
+// recordingServer starts a server that accepts every request and returns
+// its URL and the requests received so far.
+func recordingServer(t *testing.T) (string, func() []recordedRequest) {
+	requests := make(chan recordedRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := recordedRequest{Path: r.URL.Path, Authorization: r.Header.Get("Authorization")}
+		_ = json.NewDecoder(r.Body).Decode(&req.Body)
+		requests <- req
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, func() []recordedRequest {
+		var res []recordedRequest
+		for {
+			select {
+			case req := <-requests:
+				res = append(res, req)
+			default:
+				return res
+			}
+		}
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clearClientEnv unsets the environment variables read by the client
+// subcommands for the duration of the test.
+func clearClientEnv(t *testing.T) {
+	for _, name := range []string{"ACMEP_SERVER", "ACMEP_TOKEN", "ACMEP_USERNAME", "ACMEP_PASSWORD"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	credentials := writeFile(t, "file-user:file-pass\n")
+	token := writeFile(t, "file-token\n")
+	testCases := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected client.Client
+		err      string
+	}{{
+		name: "no server",
+		env:  map[string]string{"ACMEP_TOKEN": "env-token"},
+		err:  "--server not set",
+	}, {
+		name: "no credentials",
+		args: []string{"--server", "http://acmep"},
+		err:  "no credentials configured",
+	}, {
+		name: "malformed credentials file",
+		args: []string{"--server", "http://acmep", "--credentials-file", token},
+		err:  token + ": expected username:password",
+	}, {
+		name: "missing token file",
+		args: []string{"--server", "http://acmep", "--token-file", "/nonexistent/token"},
+		err:  "reading token: open /nonexistent/token: no such file or directory",
+	}, {
+		name:     "server from env",
+		env:      map[string]string{"ACMEP_SERVER": "http://env", "ACMEP_TOKEN": "env-token"},
+		expected: client.Client{Server: "http://env", Token: "env-token", Retries: client.DefaultRetries},
+	}, {
+		name:     "credentials file over env",
+		args:     []string{"--server", "http://acmep", "--credentials-file", credentials},
+		env:      map[string]string{"ACMEP_TOKEN": "env-token", "ACMEP_USERNAME": "env-user"},
+		expected: client.Client{Server: "http://acmep", Username: "file-user", Password: "file-pass", Retries: client.DefaultRetries},
+	}, {
+		name:     "token file over env",
+		args:     []string{"--server", "http://acmep", "--token-file", token},
+		env:      map[string]string{"ACMEP_TOKEN": "env-token", "ACMEP_USERNAME": "env-user"},
+		expected: client.Client{Server: "http://acmep", Token: "file-token", Retries: client.DefaultRetries},
+	}, {
+		name:     "env token over env username",
+		args:     []string{"--server", "http://acmep"},
+		env:      map[string]string{"ACMEP_TOKEN": "env-token", "ACMEP_USERNAME": "env-user", "ACMEP_PASSWORD": "env-pass"},
+		expected: client.Client{Server: "http://acmep", Token: "env-token", Retries: client.DefaultRetries},
+	}, {
+		name:     "env username",
+		args:     []string{"--server", "http://acmep"},
+		env:      map[string]string{"ACMEP_USERNAME": "env-user", "ACMEP_PASSWORD": "env-pass"},
+		expected: client.Client{Server: "http://acmep", Username: "env-user", Password: "env-pass", Retries: client.DefaultRetries},
+	}, {
+		name:     "no retries",
+		args:     []string{"--server", "http://acmep", "--retries", "0", "--lifetime", "10m"},
+		env:      map[string]string{"ACMEP_TOKEN": "env-token"},
+		expected: client.Client{Server: "http://acmep", Token: "env-token", Retries: -1, Lifetime: 10 * time.Minute},
+	}, {
+		name:     "retries",
+		args:     []string{"--server", "http://acmep", "--retries", "7"},
+		env:      map[string]string{"ACMEP_TOKEN": "env-token"},
+		expected: client.Client{Server: "http://acmep", Token: "env-token", Retries: 7},
+	}}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			clearClientEnv(t)
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			var flags clientFlags
+			flags.register(fs)
+			assert.NoError(t, fs.Parse(test.args))
+
+			c, err := flags.newClient()
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.expected, *c)
+			}
+		})
+	}
+}
+
+func TestRunClient(t *testing.T) {
+	clearClientEnv(t)
+	t.Setenv("ACMEP_TOKEN", "env-token")
+	url, requests := recordingServer(t)
+	log := quietLogger()
+
+	testCases := []struct {
+		args []string
+		path string
+		err  string
+	}{{
+		args: nil,
+		err:  "missing action",
+	}, {
+		args: []string{"present", "--server", url, "--value", "v"},
+		err:  "--fqdn and --value must be set",
+	}, {
+		args: []string{"present", "--server", url, "--fqdn", "a.domain.example"},
+		err:  "--fqdn and --value must be set",
+	}, {
+		args: []string{"present", "--unknown"},
+		err:  "flag provided but not defined: -unknown",
+	}, {
+		args: []string{"renew", "--server", url, "--fqdn", "a.domain.example", "--value", "v"},
+		err:  `unknown action "renew"`,
+	}, {
+		args: []string{"present", "--server", url, "--fqdn", "a.domain.example", "--value", "v"},
+		path: "/present",
+	}, {
+		args: []string{"cleanup", "--server", url, "--fqdn", "_acme-challenge.a.domain.example.", "--value", "v"},
+		path: "/cleanup",
+	}}
+	for _, test := range testCases {
+		err := runClient(log, test.args)
+		got := requests()
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, "%q", test.args)
+			assert.Empty(t, got, "%q", test.args)
+			continue
+		}
+		assert.NoError(t, err, "%q", test.args)
+		assert.Equal(t, []recordedRequest{{
+			Path:          test.path,
+			Authorization: "Bearer env-token",
+			Body:          map[string]string{"fqdn": "_acme-challenge.a.domain.example.", "value": "v"},
+		}}, got, "%q", test.args)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -11,14 +13,16 @@ import (
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2"
-	"github.com/caddyserver/certmagic"
 	"github.com/juju/errors"
+	miekgdns "github.com/miekg/dns"
 	"github.com/sirupsen/logrus"
 
 	"github.com/hpidcock/acme-dns-proxy/pkg/config"
 	"github.com/hpidcock/acme-dns-proxy/pkg/dns"
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns01"
 	"github.com/hpidcock/acme-dns-proxy/pkg/listener"
 	"github.com/hpidcock/acme-dns-proxy/pkg/proxy"
 )
@@ -30,6 +34,25 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "client":
+			log := logrus.New()
+			err := runClient(log, os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "certbot-hook":
+			log := logrus.New()
+			err := runCertbotHook(log, os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	var configFile string
 	var doInstall bool
 	var noExec bool
@@ -48,16 +71,25 @@ func main() {
 		return
 	}
 
+	err := run(context.Background(), log, configFile)
+	if err != nil {
+		log.Panic(err)
+	}
+	log.Info("shutdown")
+}
+
+// run serves configFile until ctx is done or a signal asks acmep to stop,
+// loading the config again on every reload.
+func run(ctx context.Context, log *logrus.Logger, configFile string) error {
+	// Challenges tracked in memory are kept across reloads.
+	store := dns.NewMemoryStore()
 	for {
-		err := cmd(log, configFile)
+		err := cmd(ctx, log, configFile, store)
 		if errors.Is(err, restartErr) {
 			log.Info("reloading")
 			continue
-		} else if err != nil {
-			log.Panic(err)
 		}
-		log.Info("shutdown")
-		break
+		return err
 	}
 }
 
@@ -98,8 +130,8 @@ func install(log *logrus.Logger, noExec bool) error {
 			return errors.Annotate(err, "survey failed")
 		}
 		configStr := fmt.Sprintf(`
-server {
-	listen_address = ":https"
+listener "public" {
+	address = ":https"
 	certmagic %q {
 	}
 }
@@ -118,19 +150,26 @@ provider "cloudflare" {
 	if err != nil {
 		return errors.Annotatef(err, "failed to parse config: %s", defaultConfigFile)
 	}
-	if cfg.Server.CertMagic != nil {
-		provider, err := dns.NewProviderFromConfig(&cfg.Provider, dns.DefaultZoneResolver)
+	resolver, err := newResolver(cfg.Resolver)
+	if err != nil {
+		return errors.Annotate(err, "invalid resolver")
+	}
+	for _, lcfg := range cfg.Listeners {
+		if lcfg.CertMagic == nil {
+			continue
+		}
+		provider, err := dns.NewProviderFromConfig(log, &cfg.Provider, resolver, nil)
 		if err != nil {
 			return errors.Annotate(err, "invalid provider")
 		}
-		certmagic.DefaultACME.DNS01Solver = &certmagic.DNS01Solver{
-			DNSProvider: provider.Underlying(),
+		cm, err := listener.NewCertMagic(lcfg.CertMagic, provider)
+		if err != nil {
+			return errors.Annotatef(err, "certmagic for listener %s", lcfg.Name)
 		}
-		certmagic.DefaultACME.Agreed = true
-		cmCfg := certmagic.NewDefault()
-		err = cmCfg.ManageSync(context.Background(), []string{cfg.Server.CertMagic.Host})
+		err = cm.Manage(context.Background())
+		cm.Stop()
 		if err != nil {
-			return errors.Annotatef(err, "certmagic listen for host %s", cfg.Server.CertMagic.Host)
+			return errors.Annotatef(err, "certmagic listen for hosts %v", lcfg.CertMagic.AllHosts())
 		}
 	}
 	bin, err := ioutil.ReadFile(self)
@@ -148,8 +187,12 @@ provider "cloudflare" {
 	return nil
 }
 
-func cmd(log *logrus.Logger, configFile string) error {
-	ctx, cancel := context.WithCancel(context.Background())
+// cmd serves configFile until ctx is done, returning restartErr when the
+// config should be reloaded. Pending challenges are tracked in memoryStore
+// unless a state file is configured. The listeners are shut down before it
+// returns.
+func cmd(ctx context.Context, log *logrus.Logger, configFile string, memoryStore dns.Store) error {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	log.Infof("parse config %s", configFile)
@@ -158,50 +201,211 @@ func cmd(log *logrus.Logger, configFile string) error {
 		return errors.Annotatef(err, "failed to parse config: %s", configFile)
 	}
 
-	provider, err := dns.NewProviderFromConfig(&cfg.Provider, dns.DefaultZoneResolver)
+	store := memoryStore
+	if cfg.StateFile != "" {
+		store, err = dns.NewFileStore(cfg.StateFile)
+		if err != nil {
+			return errors.Annotate(err, "invalid state file")
+		}
+	}
+
+	resolver, err := newResolver(cfg.Resolver)
 	if err != nil {
-		return errors.Annotate(err, "invalid provider")
+		return errors.Annotate(err, "invalid resolver")
 	}
 
-	acls, err := proxy.NewACLsFromConfig(cfg.ACLs)
+	provider, err := dns.NewProviderFromConfig(log, &cfg.Provider, resolver, store)
 	if err != nil {
-		return errors.Annotate(err, "invalid acls")
+		return errors.Annotate(err, "invalid provider")
 	}
 
-	proxy := proxy.Proxy{
+	expirer := &dns.Expirer{
 		Log:      log,
 		Provider: provider,
-		ACLs:     acls,
+		Store:    store,
+	}
+	go expirer.Run(ctx)
+
+	if cfg.GC != nil {
+		gc, err := newGarbageCollector(log, cfg.GC, provider, store)
+		if err != nil {
+			return errors.Annotate(err, "invalid gc")
+		}
+		if cfg.StateFile == "" {
+			log.Warn("gc: state_file not set, records left behind by a previous run will not be collected")
+		}
+		go gc.Run(ctx)
+	}
+
+	acls, err := proxy.NewACLsFromConfig(cfg.ACLs)
+	if err != nil {
+		return errors.Annotate(err, "invalid acls")
 	}
 
-	server := http.Server{
-		Addr: cfg.Server.ListenAddress,
+	reloadChan := make(chan struct{}, 1)
+	reload := func() {
+		select {
+		case reloadChan <- struct{}{}:
+		default:
+		}
 	}
-	if cfg.Server.CertMagic != nil {
-		certmagic.DefaultACME.DNS01Solver = &certmagic.DNS01Solver{
-			DNSProvider: provider.Underlying(),
+
+	var servers []*http.Server
+	for _, lcfg := range cfg.Listeners {
+		listenerACLs, err := acls.Select(lcfg.ACLs)
+		if err != nil {
+			return errors.Annotatef(err, "invalid acls for listener %s", lcfg.Name)
 		}
-		certmagic.DefaultACME.Agreed = true
-		cmCfg := certmagic.NewDefault()
-		err := cmCfg.ManageSync(ctx, []string{cfg.Server.CertMagic.Host})
+		server, err := listener.NewServer(lcfg, proxy.Proxy{
+			Log:      log,
+			Provider: provider,
+			ACLs:     listenerACLs,
+		}, reload)
 		if err != nil {
-			return errors.Annotatef(err, "certmagic listen for host %s", cfg.Server.CertMagic.Host)
+			return errors.Trace(err)
 		}
-		server.TLSConfig = cmCfg.TLSConfig()
-		server.TLSConfig.NextProtos = append([]string{"h2", "http/1.1"}, server.TLSConfig.NextProtos...)
+		if lcfg.CertMagic != nil {
+			cm, err := listener.NewCertMagic(lcfg.CertMagic, provider)
+			if err != nil {
+				return errors.Annotatef(err, "certmagic for listener %s", lcfg.Name)
+			}
+			defer cm.Stop()
+			err = cm.Manage(ctx)
+			if err != nil {
+				return errors.Annotatef(err, "certmagic listen for hosts %v", lcfg.CertMagic.AllHosts())
+			}
+			server.TLSConfig = cm.TLSConfig()
+		} else if lcfg.TLS != nil {
+			server.TLSConfig, err = listener.NewTLSConfig(log, lcfg.TLS)
+			if err != nil {
+				return errors.Annotatef(err, "tls for listener %s", lcfg.Name)
+			}
+		}
+		servers = append(servers, server)
 	}
 
-	done := make(chan any)
-	go listener.Serve(ctx, log, server, proxy, done)
-	defer func() { <-done }()
+	var dones []chan any
+	for i, server := range servers {
+		log.Infof("listener %s on %s", cfg.Listeners[i].Name, server.Addr)
+		done := make(chan any)
+		go listener.Serve(ctx, log, server, done)
+		dones = append(dones, done)
+	}
+	// The listeners only shut down once ctx is cancelled.
+	defer func() {
+		cancel()
+		for _, done := range dones {
+			<-done
+		}
+	}()
 
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, syscall.SIGHUP)
-	sig := <-signalChan
-	if sig == syscall.SIGHUP {
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signalChan)
+	select {
+	case sig := <-signalChan:
+		if sig == syscall.SIGHUP {
+			return restartErr
+		}
+		return nil
+	case <-reloadChan:
 		return restartErr
+	case <-ctx.Done():
+		return nil
 	}
-	return nil
+}
+
+func newGarbageCollector(log *logrus.Logger, cfg *config.GC, provider dns.Provider, store dns.Store) (*dns.GarbageCollector, error) {
+	gc := &dns.GarbageCollector{
+		Log:      log,
+		Provider: provider,
+		Store:    store,
+		Interval: time.Hour,
+		MaxAge:   24 * time.Hour,
+		DryRun:   cfg.DryRun,
+	}
+	var err error
+	if cfg.Interval != "" {
+		gc.Interval, err = time.ParseDuration(cfg.Interval)
+		if err != nil {
+			return nil, errors.Annotate(err, "interval")
+		}
+		if gc.Interval <= 0 {
+			return nil, errors.New("interval must be positive")
+		}
+	}
+	if cfg.MaxAge != "" {
+		gc.MaxAge, err = time.ParseDuration(cfg.MaxAge)
+		if err != nil {
+			return nil, errors.Annotate(err, "max_age")
+		}
+		if gc.MaxAge <= 0 {
+			return nil, errors.New("max_age must be positive")
+		}
+	}
+	return gc, nil
+}
+
+func newResolver(cfg *config.Resolver) (*dns01.Resolver, error) {
+	if cfg == nil {
+		return dns01.DefaultResolver, nil
+	}
+	r := &dns01.Resolver{
+		Nameservers: cfg.Nameservers,
+		TCPOnly:     cfg.TCPOnly,
+		DNSSEC:      cfg.DNSSEC,
+	}
+	durations := []struct {
+		name  string
+		value string
+		field *time.Duration
+	}{
+		{"timeout", cfg.Timeout, &r.Timeout},
+		{"min_cache_ttl", cfg.MinCacheTTL, &r.MinCacheTTL},
+		{"max_cache_ttl", cfg.MaxCacheTTL, &r.MaxCacheTTL},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		var err error
+		*d.field, err = time.ParseDuration(d.value)
+		if err != nil {
+			return nil, errors.Annotate(err, d.name)
+		}
+	}
+	if r.MaxCacheTTL > 0 && r.MinCacheTTL > r.MaxCacheTTL {
+		return nil, errors.New("min_cache_ttl is greater than max_cache_ttl")
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, errors.Annotate(err, "reading ca_file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		r.TLSConfig = &tls.Config{RootCAs: pool}
+	}
+	switch cfg.DNSSEC {
+	case "", dns01.DNSSECAuthenticatedData, dns01.DNSSECValidate:
+	default:
+		return nil, errors.Errorf("unsupported dnssec mode %q", cfg.DNSSEC)
+	}
+	for _, anchor := range cfg.TrustAnchors {
+		rr, err := miekgdns.NewRR(anchor)
+		if err != nil {
+			return nil, errors.Annotate(err, "trust_anchors")
+		}
+		switch rr.(type) {
+		case *miekgdns.DS, *miekgdns.DNSKEY:
+		default:
+			return nil, errors.Errorf("trust anchor %q is not a DS or DNSKEY record", anchor)
+		}
+		r.TrustAnchors = append(r.TrustAnchors, rr)
+	}
+	return r, nil
 }
 
 const serviceFile = `[Unit]
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/config"
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns"
+)
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// freeAddress returns a loopback address with a port that was free a moment
+// ago.
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// writeConfig writes a config serving the memory provider on addr, with an
+// ACL for service-0.domain.example using password and an admin listener
+// on adminAddr, and returns its path.
+func writeConfig(t *testing.T, dir, addr, adminAddr, password string) string {
+	path := filepath.Join(dir, "config.hcl")
+	err := os.WriteFile(path, []byte(fmt.Sprintf(`
+listener "test" {
+	address = %q
+}
+listener "admin" {
+	address      = %q
+	protocol     = "admin"
+	auth         = ["bearer"]
+	admin_tokens = [%q]
+}
+provider "memory" {
+	zones = ["domain.example"]
+}
+acl "service-0.domain.example" {
+	token = %q
+}
+`[1:], addr, adminAddr, tokenHash("admin-token"), tokenHash("service-0:"+password))), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func waitForListener(t *testing.T, addr string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get("http://" + addr + "/")
+		if err == nil {
+			resp.Body.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("listener on %s did not start: %v", addr, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func quietLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetLevel(logrus.WarnLevel)
+	return log
+}
+
+func TestCmdCancel(t *testing.T) {
+	addr, adminAddr := freeAddress(t), freeAddress(t)
+	configFile := writeConfig(t, t.TempDir(), addr, adminAddr, "secret")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- cmd(ctx, quietLogger(), configFile, dns.NewMemoryStore()) }()
+	waitForListener(t, addr)
+
+	cancel()
+	select {
+	case err := <-errc:
+		assert.NoError(t, err)
+	case <-time.After(15 * time.Second):
+		t.Fatal("cmd did not return after cancel")
+	}
+	_, err := http.Get("http://" + addr + "/")
+	assert.Error(t, err, "listener still serving")
+}
+
+func TestRunAdminReload(t *testing.T) {
+	dir := t.TempDir()
+	addr, adminAddr := freeAddress(t), freeAddress(t)
+	configFile := writeConfig(t, dir, addr, adminAddr, "secret")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- run(ctx, quietLogger(), configFile) }()
+	waitForListener(t, addr)
+
+	do := func(action, password, value string) int {
+		req, err := http.NewRequest("POST", "http://"+addr+"/"+action,
+			strings.NewReader(`{"fqdn": "_acme-challenge.service-0.domain.example.", "value": "`+value+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("service-0", password)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, do("present", "secret", "old"))
+
+	writeConfig(t, dir, addr, adminAddr, "new-secret")
+	req, err := http.NewRequest("POST", "http://"+adminAddr+"/reload", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	resp, err := http.DefaultClient.Do(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	deadline := time.Now().Add(15 * time.Second)
+	for do("present", "new-secret", "new") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("reloaded config is not serving")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, http.StatusUnauthorized, do("present", "secret", "new"))
+	// The challenge presented before the reload is still tracked.
+	assert.Equal(t, http.StatusOK, do("cleanup", "new-secret", "old"))
+
+	cancel()
+	select {
+	case err := <-errc:
+		assert.NoError(t, err)
+	case <-time.After(15 * time.Second):
+		t.Fatal("run did not return after cancel")
+	}
+}
+
+func TestNewGarbageCollector(t *testing.T) {
+	testCases := []struct {
+		cfg config.GC
+		err string
+	}{
+		{cfg: config.GC{}},
+		{cfg: config.GC{Interval: "10m", MaxAge: "1h"}},
+		{cfg: config.GC{Interval: "0s"}, err: "interval must be positive"},
+		{cfg: config.GC{Interval: "-1h"}, err: "interval must be positive"},
+		{cfg: config.GC{MaxAge: "0s"}, err: "max_age must be positive"},
+		{cfg: config.GC{MaxAge: "soon"}, err: `max_age: time: invalid duration "soon"`},
+	}
+	for _, test := range testCases {
+		_, err := newGarbageCollector(quietLogger(), &test.cfg, nil, nil)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, "%+v", test.cfg)
+		} else {
+			assert.NoError(t, err, "%+v", test.cfg)
+		}
+	}
+}
@@ -3,6 +3,7 @@ module github.com/hpidcock/acme-dns-proxy
 go 1.18
 
 require (
+	github.com/AlecAivazis/survey/v2 v2.3.5
 	github.com/caddyserver/certmagic v0.16.1
 	github.com/gobwas/glob v0.2.3
 	github.com/google/uuid v1.1.2
@@ -11,12 +12,14 @@ require (
 	github.com/libdns/cloudflare v0.0.0-20200528144945-97886e7873b1
 	github.com/libdns/libdns v0.2.1
 	github.com/matthiasng/libdnsfactory v0.0.0-20201026155908-87bdca3ef148
+	github.com/mholt/acmez v1.0.2
 	github.com/miekg/dns v1.1.46
 	github.com/sirupsen/logrus v1.8.1
+	github.com/stretchr/testify v1.8.0
+	github.com/zclconf/go-cty v1.10.0
 )
 
 require (
-	github.com/AlecAivazis/survey/v2 v2.3.5 // indirect
 	github.com/agext/levenshtein v1.2.3 // indirect
 	github.com/apparentlymart/go-textseg/v13 v13.0.0 // indirect
 	github.com/aws/aws-sdk-go v1.35.14 // indirect
@@ -36,13 +39,9 @@ require (
 	github.com/mattn/go-colorable v0.1.12 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
 	github.com/mgutz/ansi v0.0.0-20200706080929-d51e80ef957d // indirect
-	github.com/mholt/acmez v1.0.2 // indirect
 	github.com/mitchellh/go-wordwrap v1.0.1 // indirect
 	github.com/nrdcg/dnspod-go v0.4.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/objx v0.4.0 // indirect
-	github.com/stretchr/testify v1.8.0 // indirect
-	github.com/zclconf/go-cty v1.10.0 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
 	go.uber.org/zap v1.21.0 // indirect
@@ -0,0 +1,189 @@
+// Package client calls the httpreq API of an acmep server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns01"
+)
+
+const (
+	// DefaultRetries is the number of times a request is retried after a
+	// transient error.
+	DefaultRetries = 3
+	// DefaultRetryDelay is the delay before the first retry. It doubles
+	// with every further retry.
+	DefaultRetryDelay = time.Second
+)
+
+// Client presents and cleans up challenge records through an acmep server.
+type Client struct {
+	// Server is the base URL of the acmep server, e.g.
+	// https://acme.domain.example.
+	Server string
+	// Username and Password are sent with basic auth.
+	Username string
+	Password string
+	// Token is sent as a bearer token instead of basic auth when set.
+	Token string
+	// Lifetime asks the server to clean up the record after this long.
+	// The server's default applies when zero.
+	Lifetime time.Duration
+
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// Retries defaults to DefaultRetries, a negative value disables retries.
+	Retries int
+	// RetryDelay defaults to DefaultRetryDelay.
+	RetryDelay time.Duration
+}
+
+// Present creates the TXT record with value for the challenge of fqdn.
+// fqdn may be the domain to validate or its _acme-challenge record name.
+func (c *Client) Present(ctx context.Context, fqdn, value string) error {
+	return c.do(ctx, "present", fqdn, value)
+}
+
+// Cleanup deletes the TXT record with value for the challenge of fqdn.
+func (c *Client) Cleanup(ctx context.Context, fqdn, value string) error {
+	return c.do(ctx, "cleanup", fqdn, value)
+}
+
+// WaitForPropagation polls until the TXT record with value is served by
+// every authoritative nameserver for the challenge of fqdn, or ctx is done.
+func WaitForPropagation(ctx context.Context, fqdn, value string, resolvers []string, interval time.Duration) error {
+	name := dns01.TXTRecordName(dns01.FQDNFromTXTRecordName(fqdn))
+	for {
+		ok, err := dns01.CheckDNSPropagation(name, value, resolvers)
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return errors.Annotatef(err, "waiting for %s", name)
+			}
+			return errors.Annotatef(ctx.Err(), "waiting for %s", name)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// RequestIDHeader carries the request ID between acmep servers for
+// auditing.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context that makes the Client send id as the
+// request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+type lifetimeKey struct{}
+
+// WithLifetime returns a context that makes the Client ask the server to
+// clean up the record after lifetime, instead of after Lifetime.
+func WithLifetime(ctx context.Context, lifetime time.Duration) context.Context {
+	return context.WithValue(ctx, lifetimeKey{}, lifetime)
+}
+
+// transientError is returned for failures worth retrying.
+type transientError struct {
+	error
+}
+
+func (c *Client) do(ctx context.Context, action, fqdn, value string) error {
+	retries := c.Retries
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+	delay := c.RetryDelay
+	if delay == 0 {
+		delay = DefaultRetryDelay
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = c.request(ctx, action, fqdn, value)
+		if _, ok := err.(transientError); !ok || attempt >= retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Annotatef(err, "%s %s", action, fqdn)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	if err != nil {
+		return errors.Annotatef(err, "%s %s", action, fqdn)
+	}
+	return nil
+}
+
+func (c *Client) request(ctx context.Context, action, fqdn, value string) error {
+	payload := map[string]string{
+		"fqdn":  dns01.TXTRecordName(dns01.FQDNFromTXTRecordName(fqdn)),
+		"value": value,
+	}
+	lifetime := c.Lifetime
+	if l, ok := ctx.Value(lifetimeKey{}).(time.Duration); ok {
+		lifetime = l
+	}
+	if lifetime > 0 {
+		payload["lifetime"] = lifetime.String()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(c.Server, "/")+"/"+action, bytes.NewReader(body))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "acmep-client")
+	if id, _ := ctx.Value(requestIDKey{}).(string); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return transientError{err}
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	err = fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return transientError{err}
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/client"
+)
+
+func TestClientRetries(t *testing.T) {
+	var requests []map[string]string
+	status := []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		assert.Equal(t, "/present", r.URL.Path)
+		assert.Equal(t, "user", username)
+		assert.Equal(t, "pass", password)
+		payload := map[string]string{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		requests = append(requests, payload)
+		w.WriteHeader(status[len(requests)-1])
+	}))
+	defer srv.Close()
+
+	c := &client.Client{
+		Server:     srv.URL,
+		Username:   "user",
+		Password:   "pass",
+		Lifetime:   10 * time.Minute,
+		RetryDelay: time.Millisecond,
+	}
+	err := c.Present(context.Background(), "service-0.domain.example", "value")
+	assert.NoError(t, err)
+	assert.Len(t, requests, 3)
+	assert.Equal(t, map[string]string{
+		"fqdn":     "_acme-challenge.service-0.domain.example.",
+		"value":    "value",
+		"lifetime": "10m0s",
+	}, requests[0])
+}
+
+func TestClientNoRetryOnUnauthorized(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	c := &client.Client{
+		Server:     srv.URL,
+		Token:      "token",
+		RetryDelay: time.Millisecond,
+	}
+	err := c.Cleanup(context.Background(), "_acme-challenge.service-0.domain.example.", "value")
+	assert.ErrorContains(t, err, "401 Unauthorized")
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+
+	"github.com/juju/errors"
+	"github.com/libdns/libdns"
+)
+
+var (
+	_ libdns.RecordAppender = (*Client)(nil)
+	_ libdns.RecordDeleter  = (*Client)(nil)
+)
+
+// AppendRecords implements libdns.RecordAppender by presenting each TXT
+// record through the acmep server. Only _acme-challenge TXT records can be
+// created, which makes a Client usable as certmagic.DNS01Solver.DNSProvider.
+func (c *Client) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	var appended []libdns.Record
+	for _, rec := range recs {
+		if rec.Type != "TXT" {
+			return appended, errors.NotSupportedf("record type %q", rec.Type)
+		}
+		err := c.Present(ctx, libdns.AbsoluteName(rec.Name, zone), rec.Value)
+		if err != nil {
+			return appended, err
+		}
+		appended = append(appended, rec)
+	}
+	return appended, nil
+}
+
+// DeleteRecords implements libdns.RecordDeleter by cleaning up each TXT
+// record through the acmep server.
+func (c *Client) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	var deleted []libdns.Record
+	for _, rec := range recs {
+		if rec.Type != "TXT" {
+			return deleted, errors.NotSupportedf("record type %q", rec.Type)
+		}
+		err := c.Cleanup(ctx, libdns.AbsoluteName(rec.Name, zone), rec.Value)
+		if err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, rec)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,74 @@
+package client_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/libdns"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/client"
+	"github.com/hpidcock/acme-dns-proxy/pkg/config"
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns"
+	"github.com/hpidcock/acme-dns-proxy/pkg/listener"
+	"github.com/hpidcock/acme-dns-proxy/pkg/proxy"
+)
+
+func newAcmepServer(t *testing.T, underlying *dns.MemoryProvider) *httptest.Server {
+	provider, err := dns.NewProvider(underlying, func(string) (string, error) {
+		return "domain.example.", nil
+	}, nil)
+	assert.NoError(t, err)
+	token := sha256.Sum256([]byte("service-0:secret"))
+	server, err := listener.NewServer(config.Listener{Name: "test"}, proxy.Proxy{
+		Log:      logrus.New(),
+		Provider: provider,
+		ACLs: proxy.ACLs{{
+			Pattern:     proxy.MustCompilePattern("service-0.domain.example"),
+			Token:       hex.EncodeToString(token[:]),
+			MaxLifetime: proxy.DefaultMaxLifetime,
+		}},
+	}, nil)
+	assert.NoError(t, err)
+	srv := httptest.NewServer(server.Handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClientLibdns(t *testing.T) {
+	underlying := &dns.MemoryProvider{}
+	srv := newAcmepServer(t, underlying)
+
+	var c certmagic.ACMEDNSProvider = &client.Client{
+		Server:   srv.URL,
+		Username: "service-0",
+		Password: "secret",
+		Retries:  -1,
+	}
+	ctx := context.Background()
+	rec := libdns.Record{Type: "TXT", Name: "_acme-challenge.service-0", Value: "key auth digest"}
+
+	appended, err := c.AppendRecords(ctx, "domain.example.", []libdns.Record{rec})
+	assert.NoError(t, err)
+	assert.Equal(t, []libdns.Record{rec}, appended)
+	records, _ := underlying.GetRecords(ctx, "domain.example.")
+	assert.Len(t, records, 1)
+	assert.Equal(t, "_acme-challenge.service-0", records[0].Name)
+	assert.Equal(t, "key auth digest", records[0].Value)
+
+	deleted, err := c.DeleteRecords(ctx, "domain.example.", []libdns.Record{rec})
+	assert.NoError(t, err)
+	assert.Equal(t, []libdns.Record{rec}, deleted)
+	records, _ = underlying.GetRecords(ctx, "domain.example.")
+	assert.Empty(t, records)
+
+	_, err = c.AppendRecords(ctx, "domain.example.", []libdns.Record{{Type: "TXT", Name: "_acme-challenge.service-1", Value: "v"}})
+	assert.ErrorContains(t, err, "401 Unauthorized")
+	_, err = c.AppendRecords(ctx, "domain.example.", []libdns.Record{{Type: "A", Name: "service-0", Value: "127.0.0.1"}})
+	assert.ErrorContains(t, err, `record type "A" not supported`)
+}
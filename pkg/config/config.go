@@ -5,26 +5,167 @@ import (
 )
 
 type Config struct {
-	Server   Server   `hcl:"server,block"`
-	Provider Provider `hcl:"provider,block"`
-	ACLs     []ACL    `hcl:"acl,block"`
+	// StateFile persists pending challenges across restarts. They are only
+	// kept in memory when unset.
+	StateFile string     `hcl:"state_file,optional"`
+	Server    *Server    `hcl:"server,block"`
+	Listeners []Listener `hcl:"listener,block"`
+	Provider  Provider   `hcl:"provider,block"`
+	ACLs      []ACL      `hcl:"acl,block"`
+	GC        *GC        `hcl:"gc,block"`
+	Resolver  *Resolver  `hcl:"resolver,block"`
 }
 
+// Server is the single listener configuration from before listener blocks
+// existed. When present it is added to Listeners as DefaultListenerName.
 type Server struct {
 	ListenAddress string     `hcl:"listen_addr"`
 	CertMagic     *CertMagic `hcl:"certmagic,block"`
+	TLS           *TLS       `hcl:"tls,block"`
 }
 
+// DefaultListenerName is the name of the listener created from the server
+// block.
+const DefaultListenerName = "default"
+
+// Listener configures one HTTP server. Protocol is "httpreq" (default) or
+// "admin", and Auth defaults to ["basic"]. AdminTokens are the tokens
+// accepted by an admin listener, in the same format as ACL tokens. ACLs
+// restricts the listener to the acl blocks with the given patterns, all
+// ACLs are used when empty. Timeouts are durations such as "10s". At most
+// one of CertMagic and TLS may be set.
+type Listener struct {
+	Name              string     `hcl:"name,label"`
+	Address           string     `hcl:"address"`
+	Protocol          string     `hcl:"protocol,optional"`
+	Auth              []string   `hcl:"auth,optional"`
+	ACLs              []string   `hcl:"acls,optional"`
+	AdminTokens       []string   `hcl:"admin_tokens,optional"`
+	ReadTimeout       string     `hcl:"read_timeout,optional"`
+	ReadHeaderTimeout string     `hcl:"read_header_timeout,optional"`
+	WriteTimeout      string     `hcl:"write_timeout,optional"`
+	IdleTimeout       string     `hcl:"idle_timeout,optional"`
+	CertMagic         *CertMagic `hcl:"certmagic,block"`
+	TLS               *TLS       `hcl:"tls,block"`
+}
+
+// CertMagic configures certificates obtained over ACME through the
+// configured provider. CA is an ACME directory URL or "production"
+// (default) or "staging" for Let's Encrypt. KeyType is one of certmagic's
+// key types such as "p256" (default) or "rsa2048". Storage defaults to
+// certmagic's data directory under $HOME.
 type CertMagic struct {
-	Host string `hcl:"host,label"`
+	Host            string   `hcl:"host,label"`
+	Hosts           []string `hcl:"hosts,optional"`
+	CA              string   `hcl:"ca,optional"`
+	CARootFile      string   `hcl:"ca_root_file,optional"`
+	Email           string   `hcl:"email,optional"`
+	KeyType         string   `hcl:"key_type,optional"`
+	Storage         string   `hcl:"storage,optional"`
+	ExternalAccount *EAB     `hcl:"eab,block"`
+}
+
+// AllHosts returns Host followed by Hosts.
+func (c *CertMagic) AllHosts() []string {
+	return append([]string{c.Host}, c.Hosts...)
+}
+
+// EAB holds ACME External Account Binding credentials.
+type EAB struct {
+	KeyID  string `hcl:"key_id"`
+	MACKey string `hcl:"mac_key"`
+}
+
+// TLS configures a certificate and key loaded from PEM files, which are
+// reloaded when they change on disk. When ClientCAFile is set clients must
+// present a certificate signed by one of its CAs. MinVersion is one of
+// "1.0", "1.1", "1.2" (default) or "1.3".
+type TLS struct {
+	CertFile     string `hcl:"cert_file"`
+	KeyFile      string `hcl:"key_file"`
+	ClientCAFile string `hcl:"client_ca_file,optional"`
+	MinVersion   string `hcl:"min_version,optional"`
 }
 
+// Provider configures the DNS provider. The attributes here apply to every
+// provider type, the rest of the block is decoded by the provider.
+//
+// With FollowCNAME set, a CNAME at _acme-challenge.<domain> is followed and
+// the TXT record is written at the end of the chain, which must match one of
+// the CNAMETargets patterns.
+//
+// Aliases write the challenge records of matching domains to a fixed zone
+// instead, see Alias.
+//
+// Zones lists the zones of the provider, so the zone of a record is the
+// longest matching one instead of being looked up in DNS. ListZones does the
+// same with the zones the provider API lists, listing them again every
+// ZoneRefresh ("15m" by default). Only the cloudflare, zonefile and memory
+// providers can list zones. With DNSFallback set, the zones of names
+// outside of these zones are still looked up.
+//
+// With DryRun set, the calls that would change records are logged instead
+// of being made.
 type Provider struct {
-	Type   string   `hcl:"type,label"`
-	Remain hcl.Body `hcl:",remain"`
+	Type         string   `hcl:"type,label"`
+	FollowCNAME  bool     `hcl:"follow_cname,optional"`
+	CNAMETargets []string `hcl:"cname_targets,optional"`
+	Aliases      []Alias  `hcl:"alias,block"`
+	Zones        []string `hcl:"zones,optional"`
+	ListZones    bool     `hcl:"list_zones,optional"`
+	ZoneRefresh  string   `hcl:"zone_refresh,optional"`
+	DNSFallback  bool     `hcl:"dns_fallback,optional"`
+	DryRun       bool     `hcl:"dry_run,optional"`
+	Remain       hcl.Body `hcl:",remain"`
+
+	// EvalContext is the context the config was decoded with, for
+	// decoding Remain with the same functions and locals.
+	EvalContext *hcl.EvalContext
+}
+
+// Alias writes the challenge records of domains matching Pattern to
+// _acme-challenge.<TargetZone>, like acme.sh's --challenge-alias. The
+// _acme-challenge record of each domain must be a CNAME to that name.
+type Alias struct {
+	Pattern    string `hcl:"pattern,label"`
+	TargetZone string `hcl:"target_zone"`
+}
+
+// GC configures the garbage collector for challenge records that were never
+// cleaned up. Interval defaults to "1h" and MaxAge to "24h".
+type GC struct {
+	Interval string `hcl:"interval,optional"`
+	MaxAge   string `hcl:"max_age,optional"`
+	DryRun   bool   `hcl:"dry_run,optional"`
+}
+
+// Resolver configures the DNS queries acmep makes to discover zones and
+// follow CNAMEs. Nameservers replace the system resolvers, and may be
+// tls://host[:port] or https:// URLs for DNS over TLS or HTTPS. CAFile
+// replaces the system roots for those. Timeout is the timeout of each query
+// and defaults to "10s". MinCacheTTL and MaxCacheTTL bound how long
+// discovered zones are cached.
+//
+// DNSSEC requires validated answers, either "ad" to trust the AD bit set by
+// the nameservers or "validate" to validate answers locally. TrustAnchors
+// are the DS or DNSKEY records in zone file format "validate" trusts, and
+// default to the root zone keys.
+type Resolver struct {
+	Nameservers  []string `hcl:"nameservers,optional"`
+	CAFile       string   `hcl:"ca_file,optional"`
+	Timeout      string   `hcl:"timeout,optional"`
+	TCPOnly      bool     `hcl:"tcp_only,optional"`
+	MinCacheTTL  string   `hcl:"min_cache_ttl,optional"`
+	MaxCacheTTL  string   `hcl:"max_cache_ttl,optional"`
+	DNSSEC       string   `hcl:"dnssec,optional"`
+	TrustAnchors []string `hcl:"trust_anchors,optional"`
 }
 
+// ACL grants the holder of Token access to the domains matching Pattern.
+// MaxLifetime is the longest a challenge record may exist before acmep
+// cleans it up itself, such as "1h" (default). Clients may ask for less.
 type ACL struct {
-	Pattern string `hcl:"pattern,label"`
-	Token   string `hcl:"token"`
+	Pattern     string `hcl:"pattern,label"`
+	Token       string `hcl:"token"`
+	MaxLifetime string `hcl:"max_lifetime,optional"`
 }
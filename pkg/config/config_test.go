@@ -1,8 +1,11 @@
 package config_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/hpidcock/acme-dns-proxy/pkg/config"
@@ -27,3 +30,158 @@ acl "*.sub.domain.example" {
 `[1:])
 	assert.NoError(t, err)
 }
+
+func TestParseConfigFunctions(t *testing.T) {
+	t.Setenv("ACMEP_TEST_TOKEN", "token from env")
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "cloudflare_token"), []byte("token from file\n"), 0600)
+	assert.NoError(t, err)
+	configFile := filepath.Join(dir, "config.hcl")
+	err = os.WriteFile(configFile, []byte(`
+locals {
+	acl_token = "${local.prefix}${env("ACMEP_TEST_TOKEN")}"
+	prefix    = "service-0: "
+}
+server {
+	listen_addr = ":https"
+}
+provider "cloudflare" {
+	api_token = trimspace(file("cloudflare_token"))
+}
+acl "service-0.domain.example" {
+	token = local.acl_token
+}
+`[1:]), 0600)
+	assert.NoError(t, err)
+
+	cfg, err := config.ParseFile(configFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "service-0: token from env", cfg.ACLs[0].Token)
+
+	var provider struct {
+		APIToken string `hcl:"api_token"`
+	}
+	diags := gohcl.DecodeBody(cfg.Provider.Remain, cfg.Provider.EvalContext, &provider)
+	assert.False(t, diags.HasErrors(), diags.Error())
+	assert.Equal(t, "token from file", provider.APIToken)
+}
+
+func TestParseConfigLocalsCycle(t *testing.T) {
+	_, err := config.Parse(`
+locals {
+	a = local.b
+	b = local.a
+}
+server {
+	listen_addr = ":https"
+}
+provider "cloudflare" {
+}
+`[1:])
+	assert.ErrorContains(t, err, "Unresolvable local value")
+}
+
+func TestParseConfigEnvUnset(t *testing.T) {
+	_, err := config.Parse(`
+server {
+	listen_addr = ":https"
+}
+provider "cloudflare" {
+}
+acl "service-0.domain.example" {
+	token = env("ACMEP_TEST_UNSET")
+}
+`[1:])
+	assert.ErrorContains(t, err, `environment variable "ACMEP_TEST_UNSET" not set`)
+}
+
+func TestParseConfigListeners(t *testing.T) {
+	cfg, err := config.Parse(`
+server {
+	listen_addr = ":8080"
+}
+listener "public" {
+	address      = ":https"
+	acls         = ["*.sub.domain.example"]
+	auth         = ["bearer"]
+	read_timeout = "10s"
+	certmagic "acme.domain.example" {
+	}
+}
+provider "cloudflare" {
+}
+`[1:])
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Listeners, 2)
+	assert.Equal(t, config.DefaultListenerName, cfg.Listeners[0].Name)
+	assert.Equal(t, ":8080", cfg.Listeners[0].Address)
+	assert.Equal(t, "public", cfg.Listeners[1].Name)
+	assert.Equal(t, []string{"*.sub.domain.example"}, cfg.Listeners[1].ACLs)
+	assert.Equal(t, "acme.domain.example", cfg.Listeners[1].CertMagic.Host)
+
+	_, err = config.Parse(`
+listener "public" {
+	address = ":https"
+}
+listener "public" {
+	address = ":8080"
+}
+provider "cloudflare" {
+}
+`[1:])
+	assert.ErrorContains(t, err, `duplicate listener "public"`)
+
+	_, err = config.Parse(`
+provider "cloudflare" {
+}
+`[1:])
+	assert.ErrorContains(t, err, "no server or listener blocks defined")
+}
+
+func TestParseConfigAliases(t *testing.T) {
+	cfg, err := config.Parse(`
+server {
+	listen_addr = ":https"
+}
+provider "cloudflare" {
+	api_token = "token"
+	alias "*.corp.example" {
+		target_zone = "acme-validation.example"
+	}
+}
+`[1:])
+	assert.NoError(t, err)
+	assert.Equal(t, []config.Alias{{
+		Pattern:    "*.corp.example",
+		TargetZone: "acme-validation.example",
+	}}, cfg.Provider.Aliases)
+
+	var provider struct {
+		APIToken string `hcl:"api_token"`
+	}
+	diags := gohcl.DecodeBody(cfg.Provider.Remain, cfg.Provider.EvalContext, &provider)
+	assert.False(t, diags.HasErrors(), diags.Error())
+}
+
+func TestParseConfigResolver(t *testing.T) {
+	cfg, err := config.Parse(`
+server {
+	listen_addr = ":https"
+}
+provider "cloudflare" {
+}
+resolver {
+	nameservers   = ["10.0.0.53"]
+	timeout       = "2s"
+	tcp_only      = true
+	max_cache_ttl = "5m"
+}
+`[1:])
+	assert.NoError(t, err)
+	assert.Equal(t, &config.Resolver{
+		Nameservers: []string{"10.0.0.53"},
+		Timeout:     "2s",
+		TCPOnly:     true,
+		MaxCacheTTL: "5m",
+	}, cfg.Resolver)
+}
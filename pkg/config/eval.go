@@ -0,0 +1,151 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+)
+
+// newEvalContext returns the evaluation context used for decoding config
+// files. It exposes env(), file() and trimspace() and an empty local object
+// that is populated by decodeLocals. secret() is added by decodeSecrets.
+func newEvalContext(baseDir string) *hcl.EvalContext {
+	return &hcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"local": cty.EmptyObjectVal,
+		},
+		Functions: map[string]function.Function{
+			"env":       envFunc,
+			"file":      makeFileFunc(baseDir),
+			"trimspace": stdlib.TrimSpaceFunc,
+		},
+	}
+}
+
+// envFunc returns the value of an environment variable. Unset variables are
+// an error so a missing secret is caught when the config is loaded rather
+// than when the provider is first used.
+var envFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "name", Type: cty.String},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		name := args[0].AsString()
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return cty.NilVal, fmt.Errorf("environment variable %q not set", name)
+		}
+		return cty.StringVal(value), nil
+	},
+})
+
+// makeFileFunc returns a function that reads the contents of a file.
+// Relative paths are resolved against baseDir.
+func makeFileFunc(baseDir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "path", Type: cty.String},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			path := args[0].AsString()
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(baseDir, path)
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return cty.NilVal, fmt.Errorf("reading file: %w", err)
+			}
+			return cty.StringVal(string(b)), nil
+		},
+	})
+}
+
+// decodeLocals evaluates all locals blocks in body and stores the result as
+// the local object in ctx. Locals may refer to each other in any order. The
+// remaining body, without the locals blocks, is returned.
+func decodeLocals(body hcl.Body, ctx *hcl.EvalContext) (hcl.Body, hcl.Diagnostics) {
+	content, remain, diags := body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "locals"}},
+	})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	pending := map[string]*hcl.Attribute{}
+	for _, block := range content.Blocks {
+		attrs, moreDiags := block.Body.JustAttributes()
+		diags = append(diags, moreDiags...)
+		for name, attr := range attrs {
+			if prev, ok := pending[name]; ok {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Duplicate local value",
+					Detail:   fmt.Sprintf("A local value named %q was already defined at %s.", name, prev.NameRange),
+					Subject:  &attr.NameRange,
+				})
+				continue
+			}
+			pending[name] = attr
+		}
+	}
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	values := map[string]cty.Value{}
+	for len(pending) > 0 {
+		progress := false
+		for name, attr := range pending {
+			if !localsResolved(attr.Expr, values) {
+				continue
+			}
+			value, moreDiags := attr.Expr.Value(ctx)
+			diags = append(diags, moreDiags...)
+			if moreDiags.HasErrors() {
+				return nil, diags
+			}
+			values[name] = value
+			ctx.Variables["local"] = cty.ObjectVal(values)
+			delete(pending, name)
+			progress = true
+		}
+		if !progress {
+			for _, attr := range pending {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Unresolvable local value",
+					Detail:   fmt.Sprintf("The local value %q refers to an undefined local value or is part of a cycle.", attr.Name),
+					Subject:  &attr.NameRange,
+				})
+			}
+			return nil, diags
+		}
+	}
+
+	return remain, diags
+}
+
+// localsResolved reports whether every local.* reference in expr has
+// already been evaluated.
+func localsResolved(expr hcl.Expression, values map[string]cty.Value) bool {
+	for _, traversal := range expr.Variables() {
+		if traversal.RootName() != "local" || len(traversal) < 2 {
+			continue
+		}
+		attr, ok := traversal[1].(hcl.TraverseAttr)
+		if !ok {
+			continue
+		}
+		if _, ok := values[attr.Name]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -2,13 +2,21 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
-	"github.com/hashicorp/hcl/v2/hclsimple"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
 )
 
 func ParseFile(filename string) (*Config, error) {
-	cfg := &Config{}
-	err := hclsimple.DecodeFile(filename, nil, cfg)
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config file %s: %w", filename, err)
+	}
+	cfg, err := decode(filename, src, filepath.Dir(filename))
 	if err != nil {
 		return nil, fmt.Errorf("failed to load config file %s: %w", filename, err)
 	}
@@ -16,10 +24,75 @@ func ParseFile(filename string) (*Config, error) {
 }
 
 func Parse(config string) (*Config, error) {
-	cfg := &Config{}
-	err := hclsimple.Decode("config.hcl", []byte(config), nil, cfg)
+	cfg, err := decode("config.hcl", []byte(config), ".")
 	if err != nil {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 	return cfg, nil
 }
+
+// decode parses src as HCL (or JSON, going by the filename suffix like
+// hclsimple does), sets up secret(), evaluates any locals blocks and then
+// decodes the rest of the body into a Config. Relative paths passed to
+// file() are resolved against baseDir.
+func decode(filename string, src []byte, baseDir string) (*Config, error) {
+	parser := hclparse.NewParser()
+	var file *hcl.File
+	var diags hcl.Diagnostics
+	if strings.HasSuffix(filename, ".json") {
+		file, diags = parser.ParseJSON(src, filename)
+	} else {
+		file, diags = parser.ParseHCL(src, filename)
+	}
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	ctx := newEvalContext(baseDir)
+	body, diags := decodeSecrets(file.Body, ctx)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	body, diags = decodeLocals(body, ctx)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	cfg := &Config{}
+	diags = gohcl.DecodeBody(body, ctx, cfg)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	cfg.Provider.EvalContext = ctx
+
+	if cfg.Server != nil {
+		cfg.Listeners = append([]Listener{{
+			Name:      DefaultListenerName,
+			Address:   cfg.Server.ListenAddress,
+			CertMagic: cfg.Server.CertMagic,
+			TLS:       cfg.Server.TLS,
+		}}, cfg.Listeners...)
+	}
+	err := validateListeners(cfg.Listeners)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func validateListeners(listeners []Listener) error {
+	if len(listeners) == 0 {
+		return fmt.Errorf("no server or listener blocks defined")
+	}
+	seen := map[string]bool{}
+	for _, l := range listeners {
+		if seen[l.Name] {
+			return fmt.Errorf("duplicate listener %q", l.Name)
+		}
+		seen[l.Name] = true
+		if l.CertMagic != nil && l.TLS != nil {
+			return fmt.Errorf("listener %q: certmagic and tls are mutually exclusive", l.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// SecretProvider resolves secret references of the form scheme://...
+type SecretProvider interface {
+	// Secret returns the value of the secret referenced by ref.
+	Secret(ctx context.Context, ref *url.URL) (string, error)
+}
+
+// SecretProviders maps a reference URL scheme to its SecretProvider.
+type SecretProviders map[string]SecretProvider
+
+// Resolve parses ref and asks the provider registered for its scheme for
+// the secret value.
+func (s SecretProviders) Resolve(ctx context.Context, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid secret reference %q: %w", ref, err)
+	}
+	p, ok := s[u.Scheme]
+	if !ok {
+		return "", fmt.Errorf("invalid secret reference %q: unsupported scheme %q", ref, u.Scheme)
+	}
+	value, err := p.Secret(ctx, u)
+	if err != nil {
+		return "", fmt.Errorf("resolving secret %q: %w", ref, err)
+	}
+	return value, nil
+}
+
+// Vault configures the Vault KV v2 secret provider. Address and Token fall
+// back to VAULT_ADDR and VAULT_TOKEN.
+type Vault struct {
+	Address   string `hcl:"address,optional"`
+	Token     string `hcl:"token,optional"`
+	Namespace string `hcl:"namespace,optional"`
+}
+
+// SystemdCredentials resolves systemd-creds://name references from the
+// credentials directory systemd passes to the service.
+type SystemdCredentials struct {
+	// Directory defaults to $CREDENTIALS_DIRECTORY.
+	Directory string
+}
+
+// Secret implements SecretProvider.
+func (s *SystemdCredentials) Secret(ctx context.Context, ref *url.URL) (string, error) {
+	dir := s.Directory
+	if dir == "" {
+		dir = os.Getenv("CREDENTIALS_DIRECTORY")
+	}
+	if dir == "" {
+		return "", fmt.Errorf("CREDENTIALS_DIRECTORY not set")
+	}
+	name := ref.Host + ref.Path
+	if name == "" || strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid credential name %q", name)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// VaultKV resolves vault://mount/path#key references using the Vault KV
+// version 2 HTTP API. A version query parameter selects a specific secret
+// version, e.g. vault://kv/acmep?version=3#cloudflare.
+type VaultKV struct {
+	Address   string
+	Token     string
+	Namespace string
+	Client    *http.Client
+}
+
+// Secret implements SecretProvider.
+func (v *VaultKV) Secret(ctx context.Context, ref *url.URL) (string, error) {
+	if v.Address == "" {
+		return "", fmt.Errorf("vault address not configured")
+	}
+	mount := ref.Host
+	secretPath := strings.Trim(ref.Path, "/")
+	key := ref.Fragment
+	if mount == "" || secretPath == "" || key == "" {
+		return "", fmt.Errorf("expected vault://mount/path#key")
+	}
+
+	u, err := url.Parse(v.Address)
+	if err != nil {
+		return "", fmt.Errorf("invalid vault address: %w", err)
+	}
+	u.Path = path.Join(u.Path, "v1", mount, "data", secretPath)
+	if version := ref.Query().Get("version"); version != "" {
+		u.RawQuery = url.Values{"version": {version}}.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", fmt.Errorf("decoding vault response: %w", err)
+	}
+	value, ok := body.Data.Data[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found", key)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("key %q is not a string", key)
+	}
+	return s, nil
+}
+
+// secretTimeout bounds how long resolving a single secret may take.
+const secretTimeout = 30 * time.Second
+
+// makeSecretFunc returns a function that resolves secret references using
+// providers. Each reference is resolved at most once per config load, so
+// a reload always picks up the current value.
+func makeSecretFunc(providers SecretProviders) function.Function {
+	resolved := map[string]string{}
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "ref", Type: cty.String},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			ref := args[0].AsString()
+			if value, ok := resolved[ref]; ok {
+				return cty.StringVal(value), nil
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+			defer cancel()
+			value, err := providers.Resolve(ctx, ref)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			resolved[ref] = value
+			return cty.StringVal(value), nil
+		},
+	})
+}
+
+// decodeSecrets decodes the optional vault block in body, which may only use
+// env(), file() and trimspace(), and adds secret() to ctx. The remaining
+// body is returned.
+func decodeSecrets(body hcl.Body, ctx *hcl.EvalContext) (hcl.Body, hcl.Diagnostics) {
+	content, remain, diags := body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "vault"}},
+	})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	vault := Vault{
+		Address: os.Getenv("VAULT_ADDR"),
+		Token:   os.Getenv("VAULT_TOKEN"),
+	}
+	switch len(content.Blocks) {
+	case 0:
+	case 1:
+		moreDiags := gohcl.DecodeBody(content.Blocks[0].Body, ctx, &vault)
+		diags = append(diags, moreDiags...)
+		if moreDiags.HasErrors() {
+			return nil, diags
+		}
+	default:
+		return nil, append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Duplicate vault block",
+			Detail:   "Only one vault block is allowed.",
+			Subject:  &content.Blocks[1].DefRange,
+		})
+	}
+
+	ctx.Functions["secret"] = makeSecretFunc(SecretProviders{
+		"systemd-creds": &SystemdCredentials{},
+		"vault": &VaultKV{
+			Address:   vault.Address,
+			Token:     vault.Token,
+			Namespace: vault.Namespace,
+			Client:    &http.Client{Timeout: secretTimeout},
+		},
+	})
+	return remain, diags
+}
@@ -0,0 +1,99 @@
+package config_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/config"
+)
+
+func TestSystemdCredentials(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "cloudflare"), []byte("cf token"), 0600)
+	assert.NoError(t, err)
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+
+	providers := config.SecretProviders{"systemd-creds": &config.SystemdCredentials{}}
+	value, err := providers.Resolve(context.Background(), "systemd-creds://cloudflare")
+	assert.NoError(t, err)
+	assert.Equal(t, "cf token", value)
+
+	_, err = providers.Resolve(context.Background(), "systemd-creds://../cloudflare")
+	assert.Error(t, err)
+	_, err = providers.Resolve(context.Background(), "vault://kv/acmep#cloudflare")
+	assert.ErrorContains(t, err, `unsupported scheme "vault"`)
+}
+
+func newVaultServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault token" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/kv/data/acmep" {
+			http.NotFound(w, r)
+			return
+		}
+		value := "cf token"
+		if r.URL.Query().Get("version") == "1" {
+			value = "old cf token"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"data":{"data":{"cloudflare":%q,"count":1},"metadata":{}}}`, value)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultKV(t *testing.T) {
+	srv := newVaultServer(t)
+	providers := config.SecretProviders{"vault": &config.VaultKV{
+		Address: srv.URL,
+		Token:   "vault token",
+	}}
+
+	value, err := providers.Resolve(context.Background(), "vault://kv/acmep#cloudflare")
+	assert.NoError(t, err)
+	assert.Equal(t, "cf token", value)
+
+	value, err = providers.Resolve(context.Background(), "vault://kv/acmep?version=1#cloudflare")
+	assert.NoError(t, err)
+	assert.Equal(t, "old cf token", value)
+
+	_, err = providers.Resolve(context.Background(), "vault://kv/acmep#missing")
+	assert.ErrorContains(t, err, `key "missing" not found`)
+	_, err = providers.Resolve(context.Background(), "vault://kv/acmep#count")
+	assert.ErrorContains(t, err, `key "count" is not a string`)
+	_, err = providers.Resolve(context.Background(), "vault://kv/other#cloudflare")
+	assert.ErrorContains(t, err, "404")
+	_, err = providers.Resolve(context.Background(), "vault://kv/acmep")
+	assert.ErrorContains(t, err, "expected vault://mount/path#key")
+}
+
+func TestParseConfigSecret(t *testing.T) {
+	srv := newVaultServer(t)
+	t.Setenv("ACMEP_TEST_VAULT_TOKEN", "vault token")
+	cfg, err := config.Parse(fmt.Sprintf(`
+vault {
+	address = %q
+	token   = env("ACMEP_TEST_VAULT_TOKEN")
+}
+server {
+	listen_addr = ":https"
+}
+provider "cloudflare" {
+}
+acl "service-0.domain.example" {
+	token = secret("vault://kv/acmep#cloudflare")
+}
+`[1:], srv.URL))
+	assert.NoError(t, err)
+	assert.Equal(t, "cf token", cfg.ACLs[0].Token)
+}
@@ -1,13 +1,17 @@
 package dns
 
 import (
+	"time"
+
 	"github.com/juju/errors"
 )
 
 // Challenge holds information about an ACME challenge.
 type Challenge struct {
-	EncodedKeyAuth string // encoded key authorization value
-	FQDN           string // FQDN we want to verify
+	EncodedKeyAuth string        // encoded key authorization value
+	FQDN           string        // FQDN we want to verify
+	Principal      string        // Principal that requested the challenge, for auditing
+	Lifetime       time.Duration // Lifetime after which the record is cleaned up, if non-zero
 }
 
 func (c Challenge) Validate() error {
@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/libdns/cloudflare"
+)
+
+// cloudflareAPI is the base URL of the Cloudflare API.
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// CloudflareProvider is the libdns Cloudflare provider with zone listing,
+// which the pinned libdns version lacks.
+type CloudflareProvider struct {
+	*cloudflare.Provider
+
+	// baseURL defaults to cloudflareAPI.
+	baseURL string
+}
+
+// NewCloudflareProvider creates a Cloudflare provider authenticating with
+// apiToken.
+func NewCloudflareProvider(apiToken string) *CloudflareProvider {
+	return &CloudflareProvider{
+		Provider: &cloudflare.Provider{APIToken: apiToken},
+	}
+}
+
+// ListZones lists the zones the API token has access to, implementing
+// ZoneLister.
+func (p *CloudflareProvider) ListZones(ctx context.Context) ([]Zone, error) {
+	baseURL := p.baseURL
+	if baseURL == "" {
+		baseURL = cloudflareAPI
+	}
+	var zones []Zone
+	for page := 1; ; page++ {
+		qs := url.Values{}
+		qs.Set("page", strconv.Itoa(page))
+		qs.Set("per_page", "50")
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/zones?"+qs.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+p.APIToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var res struct {
+			Result []struct {
+				Name string `json:"name"`
+			} `json:"result"`
+			ResultInfo struct {
+				TotalPages int `json:"total_pages"`
+			} `json:"result_info"`
+			Errors []struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("listing cloudflare zones: HTTP %d: %w", resp.StatusCode, err)
+		}
+		if resp.StatusCode >= 400 || len(res.Errors) > 0 {
+			return nil, fmt.Errorf("listing cloudflare zones: HTTP %d: %+v", resp.StatusCode, res.Errors)
+		}
+		for _, z := range res.Result {
+			zones = append(zones, Zone{Name: z.Name})
+		}
+		if page >= res.ResultInfo.TotalPages {
+			return zones, nil
+		}
+	}
+}
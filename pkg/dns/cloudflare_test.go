@@ -0,0 +1,35 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloudflareProviderListZones(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/zones", r.URL.Path)
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"success": false, "errors": [{"code": 9109, "message": "Invalid access token"}]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"success": true, "result": [{"id": "%[1]s", "name": "zone-%[1]s.example"}], "result_info": {"total_pages": 2}}`, r.URL.Query().Get("page"))
+	}))
+	defer srv.Close()
+
+	p := NewCloudflareProvider("token")
+	p.baseURL = srv.URL
+	zones, err := p.ListZones(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []Zone{{Name: "zone-1.example"}, {Name: "zone-2.example"}}, zones)
+
+	p = NewCloudflareProvider("wrong")
+	p.baseURL = srv.URL
+	_, err = p.ListZones(context.Background())
+	assert.EqualError(t, err, "listing cloudflare zones: HTTP 403: [{Code:9109 Message:Invalid access token}]")
+}
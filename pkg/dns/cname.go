@@ -0,0 +1,5 @@
+package dns
+
+// CNAMEResolver returns the final target of the CNAME chain starting at an
+// FQDN, or the FQDN itself if it is not a CNAME.
+type CNAMEResolver = func(string) (string, error)
@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/libdns/libdns"
+	"github.com/matthiasng/libdnsfactory"
+	"github.com/sirupsen/logrus"
+)
+
+// DryRunProvider wraps a libdns provider and logs the calls that would
+// change records instead of making them. Records are still read from the
+// wrapped provider.
+type DryRunProvider struct {
+	Log      *logrus.Logger
+	Provider libdnsfactory.Provider
+
+	mu     sync.Mutex
+	nextID int
+}
+
+// GetRecords returns the records of the wrapped provider.
+func (d *DryRunProvider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	return d.Provider.GetRecords(ctx, zone)
+}
+
+// AppendRecords logs the call and returns recs, with an ID assigned to
+// each record that has none.
+func (d *DryRunProvider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	d.logCall("AppendRecords", zone, recs)
+	return d.withIDs(recs), nil
+}
+
+// SetRecords logs the call and returns recs, with an ID assigned to each
+// record that has none.
+func (d *DryRunProvider) SetRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	d.logCall("SetRecords", zone, recs)
+	return d.withIDs(recs), nil
+}
+
+// DeleteRecords logs the call and returns recs.
+func (d *DryRunProvider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	d.logCall("DeleteRecords", zone, recs)
+	return recs, nil
+}
+
+func (d *DryRunProvider) logCall(method, zone string, recs []libdns.Record) {
+	var formatted []string
+	for _, r := range recs {
+		formatted = append(formatted, fmt.Sprintf("{ID:%q Type:%q Name:%q Value:%q TTL:%s}",
+			r.ID, r.Type, r.Name, r.Value, r.TTL))
+	}
+	d.Log.WithFields(logrus.Fields{
+		"dry_run": true,
+		"zone":    zone,
+	}).Infof("dry run: %s(%q, [%s])", method, zone, strings.Join(formatted, " "))
+}
+
+// withIDs assigns IDs to the records without one, so the records can be
+// tracked and cleaned up like real ones.
+func (d *DryRunProvider) withIDs(recs []libdns.Record) []libdns.Record {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	res := make([]libdns.Record, 0, len(recs))
+	for _, r := range recs {
+		if r.ID == "" {
+			d.nextID++
+			r.ID = fmt.Sprintf("dry-run-%d", d.nextID)
+		}
+		res = append(res, r)
+	}
+	return res
+}
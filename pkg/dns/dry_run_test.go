@@ -0,0 +1,41 @@
+package dns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libdns/libdns"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRunProvider(t *testing.T) {
+	log, hook := logtest.NewNullLogger()
+	underlying := &MemoryProvider{}
+	p, err := NewProvider(&DryRunProvider{Log: log, Provider: underlying}, staticZone("domain.example."), nil)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, p.Present(ctx, Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "value"}))
+	records, _ := underlying.GetRecords(ctx, "domain.example.")
+	assert.Empty(t, records)
+	pending := listPending(t, p)
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, "dry-run-1", pending[0].RecordID)
+	}
+	entry := hook.LastEntry()
+	assert.Equal(t, logrus.InfoLevel, entry.Level)
+	assert.Equal(t, `dry run: AppendRecords("domain.example.", [{ID:"" Type:"TXT" Name:"_acme-challenge.a" Value:"value" TTL:1m0s}])`, entry.Message)
+	assert.Equal(t, "domain.example.", entry.Data["zone"])
+
+	assert.NoError(t, p.Cleanup(ctx, Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "value"}))
+	assert.Empty(t, listPending(t, p))
+	assert.Equal(t, `dry run: DeleteRecords("domain.example.", [{ID:"dry-run-1" Type:"TXT" Name:"_acme-challenge.a" Value:"value" TTL:0s}])`, hook.LastEntry().Message)
+
+	_, err = underlying.AppendRecords(ctx, "domain.example.", []libdns.Record{{Type: "TXT", Name: "existing"}})
+	assert.NoError(t, err)
+	records, err = p.Underlying().GetRecords(ctx, "domain.example.")
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+}
@@ -0,0 +1,197 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/libdns/libdns"
+	"github.com/sirupsen/logrus"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns01"
+)
+
+const (
+	// ExecModeArgs passes the action, the _acme-challenge FQDN and the
+	// record value as arguments, like lego's exec provider.
+	ExecModeArgs = "args"
+	// ExecModeJSON writes an ExecRequest to the command's stdin.
+	ExecModeJSON = "json"
+
+	// DefaultExecTimeout bounds a single run of an exec command.
+	DefaultExecTimeout = time.Minute
+)
+
+// ExecRequest is written to the stdin of commands in ExecModeJSON.
+type ExecRequest struct {
+	// Action is "present" or "cleanup".
+	Action string `json:"action"`
+	// FQDN is the name of the _acme-challenge TXT record.
+	FQDN  string `json:"fqdn"`
+	Value string `json:"value"`
+}
+
+// ExecCommand presents and cleans up challenge records by running an
+// external program, for DNS systems without a libdns provider.
+type ExecCommand struct {
+	Log *logrus.Logger
+	// Command is the program and its leading arguments.
+	Command []string
+	// Mode is ExecModeArgs (default) or ExecModeJSON.
+	Mode string
+	// Timeout defaults to DefaultExecTimeout.
+	Timeout time.Duration
+	// Env lists the environment variables passed on to the command, it
+	// gets an empty environment otherwise. ACMEP_REQUEST_ID is always set.
+	Env []string
+	// DryRun logs the commands instead of running them.
+	DryRun bool
+}
+
+// NewExecProvider creates a provider that runs cmd to present and clean up
+// challenge records. Pending challenges are tracked in store, or in memory
+// if store is nil. The zone of a pending challenge is unknown and left
+// empty.
+func NewExecProvider(cmd *ExecCommand, store Store) (Provider, error) {
+	if len(cmd.Command) == 0 {
+		return nil, fmt.Errorf("command not set")
+	}
+	switch cmd.Mode {
+	case "", ExecModeArgs, ExecModeJSON:
+	default:
+		return nil, fmt.Errorf("unsupported exec mode %q", cmd.Mode)
+	}
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	return &remoteProvider{
+		remote: cmd,
+		name:   "exec command",
+		store:  store,
+	}, nil
+}
+
+// Present runs the command to create the TXT record with value for the
+// challenge of fqdn.
+func (e *ExecCommand) Present(ctx context.Context, fqdn, value string) error {
+	return e.run(ctx, "present", fqdn, value)
+}
+
+// Cleanup runs the command to delete the TXT record with value for the
+// challenge of fqdn.
+func (e *ExecCommand) Cleanup(ctx context.Context, fqdn, value string) error {
+	return e.run(ctx, "cleanup", fqdn, value)
+}
+
+// AppendRecords implements libdns.RecordAppender by presenting each TXT
+// record.
+func (e *ExecCommand) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	var appended []libdns.Record
+	for _, rec := range recs {
+		if rec.Type != "TXT" {
+			return appended, errors.NotSupportedf("record type %q", rec.Type)
+		}
+		err := e.Present(ctx, libdns.AbsoluteName(rec.Name, zone), rec.Value)
+		if err != nil {
+			return appended, err
+		}
+		appended = append(appended, rec)
+	}
+	return appended, nil
+}
+
+// DeleteRecords implements libdns.RecordDeleter by cleaning up each TXT
+// record.
+func (e *ExecCommand) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	var deleted []libdns.Record
+	for _, rec := range recs {
+		if rec.Type != "TXT" {
+			return deleted, errors.NotSupportedf("record type %q", rec.Type)
+		}
+		err := e.Cleanup(ctx, libdns.AbsoluteName(rec.Name, zone), rec.Value)
+		if err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, rec)
+	}
+	return deleted, nil
+}
+
+func (e *ExecCommand) run(ctx context.Context, action, fqdn, value string) error {
+	fqdn = dns01.TXTRecordName(dns01.FQDNFromTXTRecordName(fqdn))
+	args := append([]string(nil), e.Command[1:]...)
+	var stdin []byte
+	if e.Mode == ExecModeJSON {
+		var err error
+		stdin, err = json.Marshal(ExecRequest{Action: action, FQDN: fqdn, Value: value})
+		if err != nil {
+			return errors.Trace(err)
+		}
+	} else {
+		args = append(args, action, fqdn, value)
+	}
+
+	log := e.Log.WithFields(logrus.Fields{
+		"reqID":  RequestIDFromContext(ctx),
+		"action": action,
+		"fqdn":   fqdn,
+	})
+	if e.DryRun {
+		log.WithField("dry_run", true).Infof("dry run: exec %q", append([]string{e.Command[0]}, args...))
+		return nil
+	}
+
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.Command[0], args...)
+	cmd.Env = []string{"ACMEP_REQUEST_ID=" + RequestIDFromContext(ctx)}
+	for _, name := range e.Env {
+		if v, ok := os.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, name+"="+v)
+		}
+	}
+	cmd.Stdin = bytes.NewReader(stdin)
+	// stderr goes to a file rather than a pipe, so children still holding
+	// it open don't keep Run waiting past the timeout.
+	stderr, err := os.CreateTemp("", "acmep-exec-")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer os.Remove(stderr.Name())
+	defer stderr.Close()
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	_, _ = stderr.Seek(0, io.SeekStart)
+	scanner := bufio.NewScanner(stderr)
+	var lastLine string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		log.WithField("stderr", true).Info(line)
+		lastLine = line
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s timed out after %s", e.Command[0], timeout)
+	} else if err != nil && lastLine != "" {
+		return fmt.Errorf("%s: %w: %s", e.Command[0], err, lastLine)
+	} else if err != nil {
+		return fmt.Errorf("%s: %w", e.Command[0], err)
+	}
+	return nil
+}
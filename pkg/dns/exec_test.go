@@ -0,0 +1,129 @@
+package dns
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeScript writes a shell script to a temporary directory and returns
+// its path.
+func writeScript(t *testing.T, script string) string {
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExecProviderArgs(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	t.Setenv("ACMEP_TEST_ALLOWED", "allowed")
+	t.Setenv("ACMEP_TEST_DENIED", "denied")
+	script := writeScript(t, `echo "$@" "$ACMEP_REQUEST_ID" "$ACMEP_TEST_ALLOWED" "$ACMEP_TEST_DENIED" >> "$1"`+"\n")
+	log, _ := logtest.NewNullLogger()
+	p, err := NewExecProvider(&ExecCommand{
+		Log:     log,
+		Command: []string{script, out},
+		Env:     []string{"ACMEP_TEST_ALLOWED"},
+	}, nil)
+	assert.NoError(t, err)
+
+	ctx := ContextWithRequestID(context.Background(), "request-1")
+	assert.NoError(t, p.Present(ctx, Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "value"}))
+	pending := listPending(t, p)
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, "_acme-challenge.a.domain.example.", pending[0].RecordName)
+	}
+	assert.NoError(t, p.Cleanup(ctx, Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "value"}))
+	assert.Empty(t, listPending(t, p))
+
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, out+" present _acme-challenge.a.domain.example. value request-1 allowed \n"+
+		out+" cleanup _acme-challenge.a.domain.example. value request-1 allowed \n", string(data))
+}
+
+func TestExecProviderJSON(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	script := writeScript(t, `cat >> "$1"; echo >> "$1"`+"\n")
+	log, _ := logtest.NewNullLogger()
+	p, err := NewExecProvider(&ExecCommand{
+		Log:     log,
+		Command: []string{script, out},
+		Mode:    ExecModeJSON,
+	}, nil)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = p.Underlying().AppendRecords(ctx, "domain.example.", []libdns.Record{{Type: "TXT", Name: "_acme-challenge.a", Value: "value"}})
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"action": "present", "fqdn": "_acme-challenge.a.domain.example.", "value": "value"}`, string(data))
+}
+
+func TestExecProviderErrors(t *testing.T) {
+	log, hook := logtest.NewNullLogger()
+	script := writeScript(t, "echo 'first line' >&2\necho 'zone not found' >&2\nexit 3\n")
+	p, err := NewExecProvider(&ExecCommand{Log: log, Command: []string{script}}, nil)
+	assert.NoError(t, err)
+	err = p.Present(context.Background(), Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "value"})
+	assert.EqualError(t, err, "failed to present through the exec command: "+script+": exit status 3: zone not found")
+	assert.Empty(t, listPending(t, p))
+	var lines []string
+	for _, entry := range hook.AllEntries() {
+		assert.Equal(t, true, entry.Data["stderr"])
+		lines = append(lines, entry.Message)
+	}
+	assert.Equal(t, []string{"first line", "zone not found"}, lines)
+
+	script = writeScript(t, "exec sleep 10\n")
+	p, err = NewExecProvider(&ExecCommand{Log: log, Command: []string{script}, Timeout: 100 * time.Millisecond}, nil)
+	assert.NoError(t, err)
+	err = p.Present(context.Background(), Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "value"})
+	assert.EqualError(t, err, "failed to present through the exec command: "+script+" timed out after 100ms")
+
+	// A child holding stderr open doesn't keep the command running past the
+	// timeout.
+	script = writeScript(t, "sleep 10 &\nwait\n")
+	p, err = NewExecProvider(&ExecCommand{Log: log, Command: []string{script}, Timeout: 100 * time.Millisecond}, nil)
+	assert.NoError(t, err)
+	start := time.Now()
+	err = p.Present(context.Background(), Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "value"})
+	assert.EqualError(t, err, "failed to present through the exec command: "+script+" timed out after 100ms")
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	_, err = p.Underlying().GetRecords(context.Background(), "domain.example.")
+	assert.EqualError(t, err, "the exec command cannot list records")
+	_, err = p.Underlying().SetRecords(context.Background(), "domain.example.", nil)
+	assert.EqualError(t, err, "the exec command cannot set records, only append and delete them")
+
+	_, err = NewExecProvider(&ExecCommand{Log: log}, nil)
+	assert.EqualError(t, err, "command not set")
+	_, err = NewExecProvider(&ExecCommand{Log: log, Command: []string{script}, Mode: "env"}, nil)
+	assert.EqualError(t, err, `unsupported exec mode "env"`)
+}
+
+func TestExecProviderDryRun(t *testing.T) {
+	log, hook := logtest.NewNullLogger()
+	p, err := NewExecProvider(&ExecCommand{
+		Log:     log,
+		Command: []string{"/nonexistent/hook", "--flag"},
+		DryRun:  true,
+	}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Present(context.Background(), Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "value"}))
+	entry := hook.LastEntry()
+	assert.Equal(t, logrus.InfoLevel, entry.Level)
+	assert.Equal(t, `dry run: exec ["/nonexistent/hook" "--flag" "present" "_acme-challenge.a.domain.example." "value"]`, entry.Message)
+}
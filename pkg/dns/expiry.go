@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultExpiryCheckInterval is how often an Expirer looks for new
+// deadlines when none is due sooner.
+const DefaultExpiryCheckInterval = 10 * time.Second
+
+// Expirer cleans up challenge records whose deadline has passed without a
+// client cleanup. Deadlines are read from Store, which must be the store
+// Provider uses, so with a persistent store they also fire after a restart.
+type Expirer struct {
+	Log      *logrus.Logger
+	Provider Provider
+	Store    Store
+	// CheckInterval bounds how long a newly presented challenge can go
+	// unnoticed and how often failed cleanups are retried. Defaults to
+	// DefaultExpiryCheckInterval.
+	CheckInterval time.Duration
+}
+
+// Run expires challenges until ctx is done.
+func (e *Expirer) Run(ctx context.Context) {
+	checkInterval := e.CheckInterval
+	if checkInterval <= 0 {
+		checkInterval = DefaultExpiryCheckInterval
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		next := e.Expire(ctx, time.Now())
+		wait := checkInterval
+		if !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		timer.Reset(wait)
+	}
+}
+
+// Expire cleans up all challenges with a deadline before now and returns
+// the earliest deadline still to come, or the zero time if there is none.
+func (e *Expirer) Expire(ctx context.Context, now time.Time) time.Time {
+	pending, err := e.Store.List()
+	if err != nil {
+		e.Log.Errorf("expiry: %v", err)
+		return time.Time{}
+	}
+	var next time.Time
+	for _, pc := range pending {
+		if pc.Deadline.IsZero() {
+			continue
+		}
+		if pc.Deadline.After(now) {
+			if next.IsZero() || pc.Deadline.Before(next) {
+				next = pc.Deadline
+			}
+			continue
+		}
+		log := e.Log.WithFields(logrus.Fields{
+			"audit":     "expiry",
+			"id":        pc.ID,
+			"principal": pc.Principal,
+			"fqdn":      pc.FQDN,
+			"zone":      pc.Zone,
+			"record_id": pc.RecordID,
+			"deadline":  pc.Deadline.Format(time.RFC3339),
+		})
+		log.Info("expiry: cleaning up expired challenge record")
+		err = e.Provider.ForceCleanup(ctx, pc.ID)
+		if errors.Is(err, errors.NotFound) {
+			// Cleaned up by the client in the meantime.
+			continue
+		} else if err != nil {
+			log.Errorf("expiry: %v", err)
+		}
+	}
+	return next
+}
@@ -0,0 +1,123 @@
+package dns
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/libdns/libdns"
+	"github.com/sirupsen/logrus"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns01"
+)
+
+// GarbageCollector periodically deletes the _acme-challenge TXT records
+// acmep created that no client cleaned up within MaxAge. Only records
+// tracked in Store are considered, which must be the store Provider uses.
+// Records are only collected after a restart if Store is persistent, such
+// as a FileStore.
+type GarbageCollector struct {
+	Log      *logrus.Logger
+	Provider Provider
+	Store    Store
+	Interval time.Duration
+	MaxAge   time.Duration
+	// DryRun logs what would be deleted without deleting anything.
+	DryRun bool
+}
+
+// Run sweeps every Interval until ctx is done.
+func (gc *GarbageCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(gc.Interval)
+	defer ticker.Stop()
+	for {
+		err := gc.Sweep(ctx)
+		if err != nil {
+			gc.Log.Errorf("gc: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep lists the records of every zone with challenges older than MaxAge
+// and deletes those challenge records. Challenges whose record is already
+// gone are forgotten.
+func (gc *GarbageCollector) Sweep(ctx context.Context) error {
+	pending, err := gc.Store.List()
+	if err != nil {
+		return err
+	}
+	expiredByZone := map[string][]PendingChallenge{}
+	for _, pc := range pending {
+		if time.Since(pc.Created) > gc.MaxAge {
+			expiredByZone[pc.Zone] = append(expiredByZone[pc.Zone], pc)
+		}
+	}
+
+	for zone, expired := range expiredByZone {
+		records, err := gc.Provider.Underlying().GetRecords(ctx, zone)
+		if errors.Is(err, errors.NotSupported) {
+			gc.Log.Debugf("gc: skipping zone %q: %v", zone, err)
+			continue
+		} else if err != nil {
+			gc.Log.Errorf("gc: listing records in zone %s: %v", zone, err)
+			continue
+		}
+		for _, pc := range expired {
+			log := gc.Log.WithFields(logrus.Fields{
+				"audit":       "gc",
+				"id":          pc.ID,
+				"principal":   pc.Principal,
+				"fqdn":        pc.FQDN,
+				"zone":        pc.Zone,
+				"record_id":   pc.RecordID,
+				"record_name": pc.RecordName,
+				"age":         time.Since(pc.Created).Round(time.Second).String(),
+				"dry_run":     gc.DryRun,
+			})
+			if !containsChallengeRecord(records, pc) {
+				log.Info("gc: challenge record already gone, forgetting it")
+				if !gc.DryRun {
+					err = gc.Store.Delete(pc.ID)
+					if err != nil {
+						log.Errorf("gc: %v", err)
+					}
+				}
+				continue
+			}
+			log.Info("gc: deleting orphaned challenge record")
+			if !gc.DryRun {
+				err = gc.Provider.ForceCleanup(ctx, pc.ID)
+				if err != nil {
+					log.Errorf("gc: %v", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// containsChallengeRecord reports whether records holds the challenge TXT
+// record of pc, matching by record ID or by name and value. The name is
+// usually _acme-challenge.<domain>, but may be a CNAME target.
+func containsChallengeRecord(records []libdns.Record, pc PendingChallenge) bool {
+	want := dns01.ToFQDN(libdns.AbsoluteName(pc.RecordName, pc.Zone))
+	for _, r := range records {
+		if r.Type != "TXT" {
+			continue
+		}
+		name := dns01.ToFQDN(libdns.AbsoluteName(r.Name, pc.Zone))
+		if pc.RecordID != "" && r.ID == pc.RecordID {
+			return true
+		}
+		if strings.EqualFold(name, want) && strings.Trim(r.Value, `"`) == pc.Value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package dns
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func staticZone(zone string) ZoneResolver {
+	return func(string) (string, error) {
+		return zone, nil
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := NewFileStore(path)
+	assert.NoError(t, err)
+	pc := PendingChallenge{ID: "1", FQDN: "a.domain.example.", Created: time.Now().Round(0)}
+	assert.NoError(t, store.Put(pc))
+	assert.NoError(t, store.Put(PendingChallenge{ID: "2", Created: time.Now()}))
+	assert.NoError(t, store.Delete("2"))
+
+	reopened, err := NewFileStore(path)
+	assert.NoError(t, err)
+	pending, err := reopened.List()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, pc.FQDN, pending[0].FQDN)
+	assert.True(t, pc.Created.Equal(pending[0].Created))
+	assert.Error(t, reopened.Delete("2"))
+}
+
+func TestGarbageCollector(t *testing.T) {
+	underlying := &MemoryProvider{}
+	store := NewMemoryStore()
+	p, err := NewProvider(underlying, staticZone("domain.example."), store)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	for _, fqdn := range []string{"a.domain.example.", "b.domain.example.", "c.domain.example."} {
+		err = p.Present(ctx, Challenge{FQDN: fqdn, EncodedKeyAuth: "value-" + fqdn})
+		assert.NoError(t, err)
+	}
+	pending := listPending(t, p)
+	assert.Len(t, pending, 3)
+
+	// a is orphaned, b was removed out of band, c is still fresh.
+	for _, pc := range pending[:2] {
+		pc.Created = time.Now().Add(-2 * time.Hour)
+		assert.NoError(t, store.Put(pc))
+	}
+	_, err = underlying.DeleteRecords(ctx, "domain.example.", []libdns.Record{{ID: pending[1].RecordID}})
+	assert.NoError(t, err)
+
+	gc := &GarbageCollector{
+		Log:      logrus.New(),
+		Provider: p,
+		Store:    store,
+		MaxAge:   time.Hour,
+		DryRun:   true,
+	}
+	assert.NoError(t, gc.Sweep(ctx))
+	assert.Len(t, listPending(t, p), 3)
+	records, _ := underlying.GetRecords(ctx, "domain.example.")
+	assert.Len(t, records, 2)
+
+	gc.DryRun = false
+	assert.NoError(t, gc.Sweep(ctx))
+	remaining := listPending(t, p)
+	assert.Len(t, remaining, 1)
+	assert.Equal(t, "c.domain.example.", remaining[0].FQDN)
+	records, _ = underlying.GetRecords(ctx, "domain.example.")
+	assert.Len(t, records, 1)
+	assert.Equal(t, "_acme-challenge.c", records[0].Name)
+
+	assert.NoError(t, p.Cleanup(ctx, Challenge{FQDN: "c.domain.example.", EncodedKeyAuth: "value-c.domain.example."}))
+	assert.Empty(t, listPending(t, p))
+}
+
+func TestExpirer(t *testing.T) {
+	underlying := &MemoryProvider{}
+	store := NewMemoryStore()
+	p, err := NewProvider(underlying, staticZone("domain.example."), store)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	err = p.Present(ctx, Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "a", Lifetime: time.Minute})
+	assert.NoError(t, err)
+	err = p.Present(ctx, Challenge{FQDN: "b.domain.example.", EncodedKeyAuth: "b", Lifetime: time.Hour})
+	assert.NoError(t, err)
+	err = p.Present(ctx, Challenge{FQDN: "c.domain.example.", EncodedKeyAuth: "c"})
+	assert.NoError(t, err)
+
+	e := &Expirer{Log: logrus.New(), Provider: p, Store: store}
+	pending := listPending(t, p)
+	next := e.Expire(ctx, time.Now())
+	assert.Equal(t, pending[0].Deadline, next)
+	assert.Len(t, listPending(t, p), 3)
+
+	next = e.Expire(ctx, time.Now().Add(2*time.Minute))
+	assert.Equal(t, pending[1].Deadline, next)
+	remaining := listPending(t, p)
+	assert.Len(t, remaining, 2)
+	assert.Equal(t, "b.domain.example.", remaining[0].FQDN)
+	records, _ := underlying.GetRecords(ctx, "domain.example.")
+	assert.Len(t, records, 2)
+
+	// Challenges without a deadline never expire.
+	next = e.Expire(ctx, time.Now().Add(48*time.Hour))
+	assert.True(t, next.IsZero())
+	remaining = listPending(t, p)
+	assert.Len(t, remaining, 1)
+	assert.Equal(t, "c.domain.example.", remaining[0].FQDN)
+}
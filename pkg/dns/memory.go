@@ -0,0 +1,156 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/libdns/libdns"
+)
+
+// MemoryProvider is a libdns provider that keeps records in memory, for
+// tests and for trying out acmep without a DNS provider account. Records
+// are assigned IDs when they are added. The zero value has no records.
+type MemoryProvider struct {
+	mu     sync.Mutex
+	nextID int
+	zones  map[string][]libdns.Record
+}
+
+// GetRecords returns the records of zone.
+func (m *MemoryProvider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]libdns.Record(nil), m.zones[normalizeZone(zone)]...), nil
+}
+
+// AppendRecords adds recs to zone, creating the zone if necessary.
+func (m *MemoryProvider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	zone = normalizeZone(zone)
+	var added []libdns.Record
+	for _, r := range recs {
+		r = m.withID(r)
+		m.zones[zone] = append(m.zones[zone], r)
+		added = append(added, r)
+	}
+	return added, nil
+}
+
+// SetRecords replaces the records with the same ID as one of recs. Records
+// without an ID replace all records of the same name and type.
+func (m *MemoryProvider) SetRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	zone = normalizeZone(zone)
+	var set []libdns.Record
+	for _, r := range recs {
+		if r.ID != "" {
+			if i := m.index(zone, r.ID); i >= 0 {
+				m.zones[zone][i] = r
+				set = append(set, r)
+				continue
+			}
+		} else {
+			m.zones[zone] = filterRecords(m.zones[zone], func(existing libdns.Record) bool {
+				return existing.Type == r.Type && strings.EqualFold(existing.Name, r.Name) &&
+					!containsRecord(set, existing.ID)
+			})
+		}
+		r = m.withID(r)
+		m.zones[zone] = append(m.zones[zone], r)
+		set = append(set, r)
+	}
+	return set, nil
+}
+
+// DeleteRecords deletes recs from zone. Records are matched by ID, or by
+// type, name and value when they have no ID. The value is ignored when it
+// is empty.
+func (m *MemoryProvider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	zone = normalizeZone(zone)
+	var deleted []libdns.Record
+	for _, del := range recs {
+		m.zones[zone] = filterRecords(m.zones[zone], func(r libdns.Record) bool {
+			var match bool
+			if del.ID != "" {
+				match = r.ID == del.ID
+			} else {
+				match = r.Type == del.Type && strings.EqualFold(r.Name, del.Name) &&
+					(del.Value == "" || r.Value == del.Value)
+			}
+			if match {
+				deleted = append(deleted, r)
+			}
+			return match
+		})
+	}
+	return deleted, nil
+}
+
+// ListZones lists the zones that have records, implementing ZoneLister.
+func (m *MemoryProvider) ListZones(ctx context.Context) ([]Zone, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var zones []Zone
+	for name, records := range m.zones {
+		if len(records) > 0 {
+			zones = append(zones, Zone{Name: name})
+		}
+	}
+	sort.Slice(zones, func(i, j int) bool {
+		return zones[i].Name < zones[j].Name
+	})
+	return zones, nil
+}
+
+// withID assigns the next ID to r if it has none.
+func (m *MemoryProvider) withID(r libdns.Record) libdns.Record {
+	if m.zones == nil {
+		m.zones = map[string][]libdns.Record{}
+	}
+	if r.ID == "" {
+		m.nextID++
+		r.ID = fmt.Sprint(m.nextID)
+	}
+	return r
+}
+
+func (m *MemoryProvider) index(zone, id string) int {
+	for i, r := range m.zones[zone] {
+		if r.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// filterRecords removes the records for which remove returns true.
+func filterRecords(records []libdns.Record, remove func(libdns.Record) bool) []libdns.Record {
+	kept := records[:0]
+	for _, r := range records {
+		if !remove(r) {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+func containsRecord(records []libdns.Record, id string) bool {
+	for _, r := range records {
+		if r.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeZone returns zone as a lower case FQDN.
+func normalizeZone(zone string) string {
+	return strings.ToLower(strings.TrimSuffix(zone, ".")) + "."
+}
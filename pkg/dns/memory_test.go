@@ -0,0 +1,50 @@
+package dns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libdns/libdns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryProvider(t *testing.T) {
+	ctx := context.Background()
+	m := &MemoryProvider{}
+
+	added, err := m.AppendRecords(ctx, "Domain.Example", []libdns.Record{
+		{Type: "TXT", Name: "_acme-challenge", Value: "a"},
+		{Type: "TXT", Name: "_acme-challenge", Value: "b"},
+		{Type: "A", Name: "www", Value: "127.0.0.1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "2", "3"}, []string{added[0].ID, added[1].ID, added[2].ID})
+	records, _ := m.GetRecords(ctx, "domain.example.")
+	assert.Len(t, records, 3)
+	zones, _ := m.ListZones(ctx)
+	assert.Equal(t, []Zone{{Name: "domain.example."}}, zones)
+
+	set, err := m.SetRecords(ctx, "domain.example.", []libdns.Record{
+		{Type: "TXT", Name: "_acme-challenge", Value: "c"},
+		{ID: "3", Type: "A", Name: "www", Value: "127.0.0.2"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []libdns.Record{
+		{ID: "4", Type: "TXT", Name: "_acme-challenge", Value: "c"},
+		{ID: "3", Type: "A", Name: "www", Value: "127.0.0.2"},
+	}, set)
+	records, _ = m.GetRecords(ctx, "domain.example.")
+	assert.ElementsMatch(t, set, records)
+
+	deleted, err := m.DeleteRecords(ctx, "domain.example.", []libdns.Record{
+		{Type: "TXT", Name: "_acme-challenge", Value: "other"},
+		{Type: "TXT", Name: "_acme-challenge", Value: "c"},
+		{ID: "3"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 2)
+	records, _ = m.GetRecords(ctx, "domain.example.")
+	assert.Empty(t, records)
+	zones, _ = m.ListZones(ctx)
+	assert.Empty(t, zones)
+}
@@ -3,15 +3,19 @@ package dns
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/gobwas/glob"
+	"github.com/google/uuid"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/juju/errors"
-	"github.com/libdns/cloudflare"
 	"github.com/libdns/libdns"
 	"github.com/matthiasng/libdnsfactory"
+	"github.com/sirupsen/logrus"
 
+	"github.com/hpidcock/acme-dns-proxy/pkg/client"
 	"github.com/hpidcock/acme-dns-proxy/pkg/config"
 	"github.com/hpidcock/acme-dns-proxy/pkg/dns01"
 )
@@ -20,6 +24,12 @@ import (
 type Provider interface {
 	Present(ctx context.Context, c Challenge) error
 	Cleanup(ctx context.Context, c Challenge) error
+	// Pending returns the challenges that have been presented but not yet
+	// cleaned up.
+	Pending() ([]PendingChallenge, error)
+	// ForceCleanup deletes the record of the pending challenge with the
+	// given ID.
+	ForceCleanup(ctx context.Context, id string) error
 	Underlying() interface {
 		libdns.RecordGetter
 		libdns.RecordAppender
@@ -28,64 +38,302 @@ type Provider interface {
 	}
 }
 
-// NewProviderFromConfig creates a new provider from a config.Provider instance
-func NewProviderFromConfig(cfg *config.Provider, resolver ZoneResolver) (Provider, error) {
+// NewProviderFromConfig creates a new provider from a config.Provider instance.
+// Zones are discovered and CNAMEs followed with resolver, or with
+// dns01.DefaultResolver if resolver is nil. Pending challenges are tracked
+// in store, or in memory if store is nil. Dry run calls are logged to log.
+func NewProviderFromConfig(log *logrus.Logger, cfg *config.Provider, resolver *dns01.Resolver, store Store) (Provider, error) {
 	if len(cfg.Type) == 0 {
 		return nil, fmt.Errorf("error initializing provider: provider type not specified")
 	}
+	if resolver == nil {
+		resolver = dns01.DefaultResolver
+	}
 
-	p := &provider{
-		pendingRecords: map[string]pendingRecord{},
+	var cnameTargets []glob.Glob
+	for _, target := range cfg.CNAMETargets {
+		g, err := glob.Compile(dns01.UnFQDN(target))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cname target %q: %w", target, err)
+		}
+		cnameTargets = append(cnameTargets, g)
+	}
+	if cfg.FollowCNAME && len(cnameTargets) == 0 {
+		return nil, fmt.Errorf("follow_cname requires cname_targets")
+	}
+
+	var aliases []alias
+	for _, a := range cfg.Aliases {
+		g, err := glob.Compile(dns01.UnFQDN(a.Pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid alias pattern %q: %w", a.Pattern, err)
+		}
+		if dns01.UnFQDN(a.TargetZone) == "" {
+			return nil, fmt.Errorf("alias %q: target_zone not set", a.Pattern)
+		}
+		aliases = append(aliases, alias{
+			pattern: g,
+			zone:    dns01.ToFQDN(strings.ToLower(a.TargetZone)),
+		})
 	}
 
+	var underlying libdnsfactory.Provider
 	switch cfg.Type {
+	case "acmep":
+		var c struct {
+			Server   string `hcl:"server"`
+			Username string `hcl:"username,optional"`
+			Password string `hcl:"password,optional"`
+			Token    string `hcl:"token,optional"`
+		}
+		err := gohcl.DecodeBody(cfg.Remain, cfg.EvalContext, &c)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(aliases) > 0 {
+			return nil, fmt.Errorf("alias is not supported by the acmep provider")
+		}
+		if len(cfg.Zones) > 0 || cfg.ListZones {
+			return nil, fmt.Errorf("zones and list_zones are not supported by the acmep provider")
+		}
+		if cfg.DryRun {
+			return nil, fmt.Errorf("dry_run is not supported by the acmep provider, set it on the upstream server")
+		}
+		if cfg.FollowCNAME || len(cfg.CNAMETargets) > 0 || cfg.DNSFallback {
+			return nil, fmt.Errorf("follow_cname, cname_targets and dns_fallback are not supported by the acmep provider, set them on the upstream server")
+		}
+		return NewUpstreamProvider(&client.Client{
+			Server:   c.Server,
+			Username: c.Username,
+			Password: c.Password,
+			Token:    c.Token,
+		}, store), nil
+	case "exec":
+		var c struct {
+			Command []string `hcl:"command"`
+			Mode    string   `hcl:"mode,optional"`
+			Timeout string   `hcl:"timeout,optional"`
+			Env     []string `hcl:"env,optional"`
+		}
+		err := gohcl.DecodeBody(cfg.Remain, cfg.EvalContext, &c)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(aliases) > 0 {
+			return nil, fmt.Errorf("alias is not supported by the exec provider")
+		}
+		if len(cfg.Zones) > 0 || cfg.ListZones {
+			return nil, fmt.Errorf("zones and list_zones are not supported by the exec provider")
+		}
+		if cfg.FollowCNAME || len(cfg.CNAMETargets) > 0 || cfg.DNSFallback {
+			return nil, fmt.Errorf("follow_cname, cname_targets and dns_fallback are not supported by the exec provider")
+		}
+		cmd := &ExecCommand{
+			Log:     log,
+			Command: c.Command,
+			Mode:    c.Mode,
+			Env:     c.Env,
+			DryRun:  cfg.DryRun,
+		}
+		if c.Timeout != "" {
+			timeout, err := time.ParseDuration(c.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout: %w", err)
+			}
+			cmd.Timeout = timeout
+		}
+		return NewExecProvider(cmd, store)
 	case "cloudflare":
 		var c struct {
 			APIToken string `hcl:"api_token"`
 		}
-		err := gohcl.DecodeBody(cfg.Remain, nil, &c)
+		err := gohcl.DecodeBody(cfg.Remain, cfg.EvalContext, &c)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
-		p.provider = &cloudflare.Provider{
-			APIToken: c.APIToken,
+		underlying = NewCloudflareProvider(c.APIToken)
+	case "zonefile":
+		var c struct {
+			Zones []struct {
+				Origin        string   `hcl:"origin,label"`
+				File          string   `hcl:"file"`
+				ReloadCommand []string `hcl:"reload_command,optional"`
+			} `hcl:"zone,block"`
+			ReloadTimeout string `hcl:"reload_timeout,optional"`
+		}
+		err := gohcl.DecodeBody(cfg.Remain, cfg.EvalContext, &c)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(c.Zones) == 0 {
+			return nil, fmt.Errorf("no zone blocks defined")
+		}
+		zf := &ZoneFileProvider{Log: log}
+		for _, z := range c.Zones {
+			zf.Zones = append(zf.Zones, ZoneFile{
+				Origin:        z.Origin,
+				Path:          z.File,
+				ReloadCommand: z.ReloadCommand,
+			})
 		}
+		if c.ReloadTimeout != "" {
+			timeout, err := time.ParseDuration(c.ReloadTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("invalid reload_timeout: %w", err)
+			}
+			zf.ReloadTimeout = timeout
+		}
+		if len(cfg.Zones) == 0 && !cfg.ListZones {
+			// The zones are known, there's no need to look them up.
+			zonesCfg := *cfg
+			zonesCfg.ListZones = true
+			cfg = &zonesCfg
+		}
+		underlying = zf
+	case "memory":
+		var c struct{}
+		err := gohcl.DecodeBody(cfg.Remain, cfg.EvalContext, &c)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		underlying = &MemoryProvider{}
 	default:
 		return nil, fmt.Errorf("unsupported provider %q", cfg.Type)
 	}
 
+	zoneResolver, err := newZoneResolverFromConfig(cfg, underlying, resolver)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.DryRun {
+		underlying = &DryRunProvider{Log: log, Provider: underlying}
+	}
+
+	p := newProvider(underlying, zoneResolver, store)
+	p.aliases = aliases
+	if cfg.FollowCNAME {
+		p.cnameResolver = resolver.ResolveCNAME
+		p.cnameTargets = cnameTargets
+	}
 	return p, nil
 }
 
-// NewProvider creates a new provider
-func NewProvider(p libdnsfactory.Provider, resolver ZoneResolver) (Provider, error) {
+// newZoneResolverFromConfig returns the ZoneResolver for the zones, list_zones
+// and dns_fallback attributes of cfg.
+func newZoneResolverFromConfig(cfg *config.Provider, underlying libdnsfactory.Provider, resolver *dns01.Resolver) (ZoneResolver, error) {
+	var fallback ZoneResolver
+	if cfg.DNSFallback {
+		fallback = resolver.FindZoneByFQDN
+	}
+	switch {
+	case len(cfg.Zones) > 0 && cfg.ListZones:
+		return nil, fmt.Errorf("zones and list_zones are mutually exclusive")
+	case len(cfg.Zones) > 0:
+		return NewStaticZoneResolver(cfg.Zones, fallback), nil
+	case cfg.ListZones:
+		lister, ok := underlying.(ZoneLister)
+		if !ok {
+			return nil, fmt.Errorf("provider %q cannot list zones", cfg.Type)
+		}
+		r := &ListedZoneResolver{
+			Lister:   lister,
+			Fallback: fallback,
+		}
+		if cfg.ZoneRefresh != "" {
+			refresh, err := time.ParseDuration(cfg.ZoneRefresh)
+			if err != nil {
+				return nil, fmt.Errorf("invalid zone_refresh: %w", err)
+			}
+			r.Refresh = refresh
+		}
+		return r.Resolve, nil
+	case cfg.DNSFallback:
+		return nil, fmt.Errorf("dns_fallback requires zones or list_zones")
+	}
+	return resolver.FindZoneByFQDN, nil
+}
+
+// NewProvider creates a new provider. Pending challenges are tracked in
+// store, or in memory if store is nil.
+func NewProvider(p libdnsfactory.Provider, resolver ZoneResolver, store Store) (Provider, error) {
+	return newProvider(p, resolver, store), nil
+}
+
+func newProvider(p libdnsfactory.Provider, resolver ZoneResolver, store Store) *provider {
+	if store == nil {
+		store = NewMemoryStore()
+	}
 	return &provider{
-		provider:       p,
-		zoneResolver:   resolver,
-		pendingRecords: map[string]pendingRecord{},
-	}, nil
+		provider:     p,
+		zoneResolver: resolver,
+		store:        store,
+	}
 }
 
 type provider struct {
 	provider     libdnsfactory.Provider
 	zoneResolver ZoneResolver
+	store        Store
+
+	// cnameResolver is set when CNAMEs at the challenge record name are
+	// followed to one of cnameTargets.
+	cnameResolver CNAMEResolver
+	cnameTargets  []glob.Glob
+
+	// aliases are checked in order before any CNAME is followed.
+	aliases []alias
+
+	// cleanupMutex stops a client cleanup and a forced cleanup from
+	// both deleting the same record.
+	cleanupMutex sync.Mutex
+}
+
+// alias writes the challenge records of domains matching pattern to
+// _acme-challenge.<zone>.
+type alias struct {
+	pattern glob.Glob
+	zone    string
+}
 
-	pendingRecords      map[string]pendingRecord
-	pendingRecordsMutex sync.Mutex
+// PendingChallenge describes a challenge record that has not been cleaned up.
+type PendingChallenge struct {
+	ID         string    `json:"id"`
+	Principal  string    `json:"principal"`
+	FQDN       string    `json:"fqdn"`
+	Zone       string    `json:"zone"`
+	RecordID   string    `json:"record_id"`
+	RecordName string    `json:"record_name"`
+	Value      string    `json:"value"`
+	Created    time.Time `json:"created"`
+	// Deadline is when acmep cleans up the record itself, if set.
+	Deadline time.Time `json:"deadline,omitempty"`
 }
 
-type pendingRecord struct {
-	recordID string
-	zone     string
+// Record returns the libdns record for the pending challenge.
+func (pc PendingChallenge) Record() libdns.Record {
+	return libdns.Record{
+		ID:    pc.RecordID,
+		Type:  "TXT",
+		Name:  pc.RecordName,
+		Value: pc.Value,
+	}
 }
 
 func (l *provider) Present(ctx context.Context, c Challenge) error {
-	zone, err := l.zoneResolver(c.FQDN)
+	target, zone, err := l.challengeTarget(c.FQDN)
 	if err != nil {
 		return fmt.Errorf("failed to append record: %w", err)
 	}
 
-	recordName := dns01.UnFQDN(dns01.RemoveZoneFromFQDN(dns01.TXTRecordName(c.FQDN), zone))
+	if zone == "" {
+		zone, err = l.zoneResolver(target)
+		if err != nil {
+			return fmt.Errorf("failed to append record: %w", err)
+		}
+	}
+
+	recordName := dns01.UnFQDN(dns01.RemoveZoneFromFQDN(target, zone))
 	record := libdns.Record{
 		Type:  "TXT",
 		Name:  recordName,
@@ -98,29 +346,119 @@ func (l *provider) Present(ctx context.Context, c Challenge) error {
 		return fmt.Errorf("failed to append record: %w", err)
 	}
 
-	l.pendingRecordsMutex.Lock()
-	defer l.pendingRecordsMutex.Unlock()
-	l.pendingRecords[c.EncodedKeyAuth] = pendingRecord{
-		recordID: records[0].ID,
-		zone:     zone,
+	now := time.Now()
+	pending := PendingChallenge{
+		ID:         uuid.New().String(),
+		Principal:  c.Principal,
+		FQDN:       c.FQDN,
+		Zone:       zone,
+		RecordID:   records[0].ID,
+		RecordName: recordName,
+		Value:      c.EncodedKeyAuth,
+		Created:    now,
+	}
+	if c.Lifetime > 0 {
+		pending.Deadline = now.Add(c.Lifetime)
+	}
+	err = l.store.Put(pending)
+	if err != nil {
+		return fmt.Errorf("failed to track record: %w", err)
 	}
 	return nil
 }
 
+// challengeTarget returns the FQDN to write the challenge record for fqdn
+// at, using a matching alias or following a CNAME if enabled. The zone is
+// returned too when an alias determines it, and is empty otherwise.
+func (l *provider) challengeTarget(fqdn string) (string, string, error) {
+	domain := strings.ToLower(dns01.UnFQDN(fqdn))
+	for _, a := range l.aliases {
+		if a.pattern.Match(domain) {
+			return dns01.TXTRecordName(a.zone), a.zone, nil
+		}
+	}
+
+	name := dns01.TXTRecordName(fqdn)
+	if l.cnameResolver == nil {
+		return name, "", nil
+	}
+	target, err := l.cnameResolver(name)
+	if err != nil {
+		return "", "", err
+	}
+	if target == name {
+		return name, "", nil
+	}
+	for _, g := range l.cnameTargets {
+		if g.Match(strings.ToLower(dns01.UnFQDN(target))) {
+			return target, "", nil
+		}
+	}
+	return "", "", fmt.Errorf("CNAME target %s of %s not allowed", target, name)
+}
+
 func (l *provider) Cleanup(ctx context.Context, c Challenge) error {
-	pendingRecord, err := l.popPendingRecordID(c.EncodedKeyAuth)
+	l.cleanupMutex.Lock()
+	defer l.cleanupMutex.Unlock()
+
+	pending, err := findPending(l.store, func(pc PendingChallenge) bool {
+		return pc.Value == c.EncodedKeyAuth
+	})
 	if err != nil {
 		return fmt.Errorf("failed to cleanup record: %w", err)
 	}
 
-	_, err = l.provider.DeleteRecords(ctx, pendingRecord.zone, []libdns.Record{{ID: pendingRecord.recordID}})
+	return l.cleanupLocked(ctx, pending)
+}
+
+func (l *provider) Pending() ([]PendingChallenge, error) {
+	pending, err := l.store.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending challenges: %w", err)
+	}
+	return pending, nil
+}
+
+func (l *provider) ForceCleanup(ctx context.Context, id string) error {
+	l.cleanupMutex.Lock()
+	defer l.cleanupMutex.Unlock()
+
+	pending, err := findPending(l.store, func(pc PendingChallenge) bool {
+		return pc.ID == id
+	})
+	if err != nil {
+		return errors.NotFoundf("pending challenge %q", id)
+	}
+
+	return l.cleanupLocked(ctx, pending)
+}
+
+func (l *provider) cleanupLocked(ctx context.Context, pending PendingChallenge) error {
+	_, err := l.provider.DeleteRecords(ctx, pending.Zone, []libdns.Record{pending.Record()})
 	if err != nil {
 		return fmt.Errorf("failed to cleanup record: %w", err)
 	}
 
+	err = l.store.Delete(pending.ID)
+	if err != nil {
+		return fmt.Errorf("failed to untrack record: %w", err)
+	}
 	return nil
 }
 
+func findPending(store Store, match func(PendingChallenge) bool) (PendingChallenge, error) {
+	pending, err := store.List()
+	if err != nil {
+		return PendingChallenge{}, err
+	}
+	for _, pc := range pending {
+		if match(pc) {
+			return pc, nil
+		}
+	}
+	return PendingChallenge{}, errors.NotFoundf("pending record")
+}
+
 func (l *provider) Underlying() interface {
 	libdns.RecordGetter
 	libdns.RecordAppender
@@ -129,15 +467,3 @@ func (l *provider) Underlying() interface {
 } {
 	return l.provider
 }
-
-func (l *provider) popPendingRecordID(encKeyAuth string) (*pendingRecord, error) {
-	l.pendingRecordsMutex.Lock()
-	defer l.pendingRecordsMutex.Unlock()
-
-	if pr, ok := l.pendingRecords[encKeyAuth]; ok {
-		delete(l.pendingRecords, encKeyAuth)
-		return &pr, nil
-	}
-
-	return nil, fmt.Errorf("no pending record found [encKeyAuth: %s]", encKeyAuth)
-}
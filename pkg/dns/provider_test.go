@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gobwas/glob"
+	"github.com/stretchr/testify/assert"
+)
+
+func listPending(t *testing.T, p Provider) []PendingChallenge {
+	pending, err := p.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pending
+}
+
+func TestProviderFollowCNAME(t *testing.T) {
+	underlying := &MemoryProvider{}
+	p := newProvider(underlying, func(fqdn string) (string, error) {
+		if fqdn == "_acme-challenge.a.domain.example." {
+			return "domain.example.", nil
+		}
+		return "validation.example.", nil
+	}, nil)
+	p.cnameResolver = func(fqdn string) (string, error) {
+		switch fqdn {
+		case "_acme-challenge.b.domain.example.":
+			return "b.validation.example.", nil
+		case "_acme-challenge.c.domain.example.":
+			return "c.elsewhere.example.", nil
+		}
+		return fqdn, nil
+	}
+	p.cnameTargets = []glob.Glob{glob.MustCompile("*.validation.example")}
+
+	ctx := context.Background()
+	assert.NoError(t, p.Present(ctx, Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "a"}))
+	assert.NoError(t, p.Present(ctx, Challenge{FQDN: "b.domain.example.", EncodedKeyAuth: "b"}))
+	err := p.Present(ctx, Challenge{FQDN: "c.domain.example.", EncodedKeyAuth: "c"})
+	assert.ErrorContains(t, err, "CNAME target c.elsewhere.example. of _acme-challenge.c.domain.example. not allowed")
+
+	records, _ := underlying.GetRecords(ctx, "domain.example.")
+	assert.Len(t, records, 1)
+	assert.Equal(t, "_acme-challenge.a", records[0].Name)
+	records, _ = underlying.GetRecords(ctx, "validation.example.")
+	assert.Len(t, records, 1)
+	assert.Equal(t, "b", records[0].Name)
+
+	pending := listPending(t, p)
+	assert.Len(t, pending, 2)
+	assert.Equal(t, "b.domain.example.", pending[1].FQDN)
+	assert.Equal(t, "validation.example.", pending[1].Zone)
+
+	assert.NoError(t, p.Cleanup(ctx, Challenge{FQDN: "b.domain.example.", EncodedKeyAuth: "b"}))
+	records, _ = underlying.GetRecords(ctx, "validation.example.")
+	assert.Empty(t, records)
+}
+
+func TestProviderAlias(t *testing.T) {
+	underlying := &MemoryProvider{}
+	p := newProvider(underlying, func(fqdn string) (string, error) {
+		return "domain.example.", nil
+	}, nil)
+	p.aliases = []alias{{
+		pattern: glob.MustCompile("*.corp.example"),
+		zone:    "acme-validation.example.",
+	}}
+
+	ctx := context.Background()
+	assert.NoError(t, p.Present(ctx, Challenge{FQDN: "a.corp.example.", EncodedKeyAuth: "a"}))
+	assert.NoError(t, p.Present(ctx, Challenge{FQDN: "B.Corp.Example.", EncodedKeyAuth: "b"}))
+	assert.NoError(t, p.Present(ctx, Challenge{FQDN: "c.domain.example.", EncodedKeyAuth: "c"}))
+
+	records, _ := underlying.GetRecords(ctx, "acme-validation.example.")
+	assert.Len(t, records, 2)
+	assert.Equal(t, "_acme-challenge", records[0].Name)
+	assert.Equal(t, "_acme-challenge", records[1].Name)
+	records, _ = underlying.GetRecords(ctx, "domain.example.")
+	assert.Len(t, records, 1)
+	assert.Equal(t, "_acme-challenge.c", records[0].Name)
+
+	assert.NoError(t, p.Cleanup(ctx, Challenge{FQDN: "a.corp.example.", EncodedKeyAuth: "a"}))
+	records, _ = underlying.GetRecords(ctx, "acme-validation.example.")
+	assert.Len(t, records, 1)
+	assert.Equal(t, "b", records[0].Value)
+}
@@ -0,0 +1,17 @@
+package dns
+
+import "context"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a context carrying the ID of the request a
+// provider call is made for.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID stored in ctx, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
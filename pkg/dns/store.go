@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// Store keeps track of the challenge records acmep has created, so they
+// can be cleaned up after a reload or restart.
+type Store interface {
+	// Put adds or replaces the pending challenge with the same ID.
+	Put(pc PendingChallenge) error
+	// Delete removes the pending challenge with the given ID.
+	Delete(id string) error
+	// List returns all pending challenges, oldest first.
+	List() ([]PendingChallenge, error)
+}
+
+// NewMemoryStore returns a Store that only lives as long as the process.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		pending: map[string]PendingChallenge{},
+	}
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	pending map[string]PendingChallenge
+}
+
+func (s *memoryStore) Put(pc PendingChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[pc.ID] = pc
+	return nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[id]; !ok {
+		return errors.NotFoundf("pending challenge %q", id)
+	}
+	delete(s.pending, id)
+	return nil
+}
+
+func (s *memoryStore) List() ([]PendingChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := make([]PendingChallenge, 0, len(s.pending))
+	for _, pc := range s.pending {
+		pending = append(pending, pc)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Created.Before(pending[j].Created)
+	})
+	return pending, nil
+}
+
+// NewFileStore returns a Store persisted as JSON to path. The file is
+// rewritten atomically on every change.
+func NewFileStore(path string) (Store, error) {
+	s := &fileStore{
+		path: path,
+		memoryStore: memoryStore{
+			pending: map[string]PendingChallenge{},
+		},
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+	var pending []PendingChallenge
+	err = json.Unmarshal(b, &pending)
+	if err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+	for _, pc := range pending {
+		s.pending[pc.ID] = pc
+	}
+	return s, nil
+}
+
+type fileStore struct {
+	memoryStore
+	path string
+}
+
+func (s *fileStore) Put(pc PendingChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, existed := s.pending[pc.ID]
+	s.pending[pc.ID] = pc
+	err := s.writeLocked()
+	if err != nil {
+		if existed {
+			s.pending[pc.ID] = prev
+		} else {
+			delete(s.pending, pc.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.pending[id]
+	if !ok {
+		return errors.NotFoundf("pending challenge %q", id)
+	}
+	delete(s.pending, id)
+	err := s.writeLocked()
+	if err != nil {
+		s.pending[id] = prev
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) writeLocked() error {
+	pending := make([]PendingChallenge, 0, len(s.pending))
+	for _, pc := range s.pending {
+		pending = append(pending, pc)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Created.Before(pending[j].Created)
+	})
+	b, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return writeFileAtomic(s.path, b, 0600)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Annotatef(err, "writing %s", f.Name())
+	}
+	err = os.Chmod(f.Name(), perm)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(f.Name(), path))
+}
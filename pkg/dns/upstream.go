@@ -0,0 +1,167 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/juju/errors"
+	"github.com/libdns/libdns"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/client"
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns01"
+)
+
+// NewUpstreamProvider creates a provider that forwards challenges to an
+// upstream acmep server, which holds the DNS provider credentials. Request
+// IDs are passed on so both servers log the same ID. Challenge lifetimes
+// are passed on too, and the upstream cleans up expired records itself, so
+// expired challenges are only forgotten here. Pending challenges are
+// tracked in store, or in memory if store is nil. The zone of a pending
+// challenge is unknown and left empty.
+func NewUpstreamProvider(c *client.Client, store Store) Provider {
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	return &remoteProvider{
+		remote:        c,
+		name:          "upstream acmep server",
+		store:         store,
+		remoteExpires: true,
+	}
+}
+
+// remote presents and cleans up challenge records in zones acmep doesn't
+// know about. fqdn is the domain or its _acme-challenge record name.
+type remote interface {
+	Present(ctx context.Context, fqdn, value string) error
+	Cleanup(ctx context.Context, fqdn, value string) error
+	libdns.RecordAppender
+	libdns.RecordDeleter
+}
+
+// remoteProvider tracks the challenges presented through a remote.
+type remoteProvider struct {
+	remote remote
+	// name describes the remote in errors, e.g. "exec command".
+	name  string
+	store Store
+	// remoteExpires is set when the remote is given the lifetime of each
+	// challenge and cleans up the record itself once it has passed.
+	remoteExpires bool
+
+	cleanupMutex sync.Mutex
+}
+
+func (u *remoteProvider) Present(ctx context.Context, c Challenge) error {
+	ctx = client.WithRequestID(ctx, RequestIDFromContext(ctx))
+	if c.Lifetime > 0 {
+		ctx = client.WithLifetime(ctx, c.Lifetime)
+	}
+	err := u.remote.Present(ctx, c.FQDN, c.EncodedKeyAuth)
+	if err != nil {
+		return fmt.Errorf("failed to present through the %s: %w", u.name, err)
+	}
+
+	now := time.Now()
+	pending := PendingChallenge{
+		ID:         uuid.New().String(),
+		Principal:  c.Principal,
+		FQDN:       c.FQDN,
+		RecordName: dns01.TXTRecordName(c.FQDN),
+		Value:      c.EncodedKeyAuth,
+		Created:    now,
+	}
+	if c.Lifetime > 0 {
+		pending.Deadline = now.Add(c.Lifetime)
+	}
+	err = u.store.Put(pending)
+	if err != nil {
+		return fmt.Errorf("failed to track record: %w", err)
+	}
+	return nil
+}
+
+func (u *remoteProvider) Cleanup(ctx context.Context, c Challenge) error {
+	u.cleanupMutex.Lock()
+	defer u.cleanupMutex.Unlock()
+
+	pending, err := findPending(u.store, func(pc PendingChallenge) bool {
+		return pc.Value == c.EncodedKeyAuth
+	})
+	if err != nil {
+		return fmt.Errorf("failed to cleanup record: %w", err)
+	}
+
+	return u.cleanupLocked(ctx, pending)
+}
+
+func (u *remoteProvider) Pending() ([]PendingChallenge, error) {
+	pending, err := u.store.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending challenges: %w", err)
+	}
+	return pending, nil
+}
+
+func (u *remoteProvider) ForceCleanup(ctx context.Context, id string) error {
+	u.cleanupMutex.Lock()
+	defer u.cleanupMutex.Unlock()
+
+	pending, err := findPending(u.store, func(pc PendingChallenge) bool {
+		return pc.ID == id
+	})
+	if err != nil {
+		return errors.NotFoundf("pending challenge %q", id)
+	}
+	if u.remoteExpires && !pending.Deadline.IsZero() && !time.Now().Before(pending.Deadline) {
+		// The deadline here is set after the remote's, so the record is
+		// already gone and a cleanup would be refused.
+		return u.untrack(pending)
+	}
+
+	return u.cleanupLocked(ctx, pending)
+}
+
+func (u *remoteProvider) cleanupLocked(ctx context.Context, pending PendingChallenge) error {
+	ctx = client.WithRequestID(ctx, RequestIDFromContext(ctx))
+	err := u.remote.Cleanup(ctx, pending.FQDN, pending.Value)
+	if err != nil {
+		return fmt.Errorf("failed to cleanup through the %s: %w", u.name, err)
+	}
+	return u.untrack(pending)
+}
+
+func (u *remoteProvider) untrack(pending PendingChallenge) error {
+	err := u.store.Delete(pending.ID)
+	if err != nil {
+		return fmt.Errorf("failed to untrack record: %w", err)
+	}
+	return nil
+}
+
+// Underlying returns the remote as a libdns provider. It can only append
+// and delete _acme-challenge TXT records.
+func (u *remoteProvider) Underlying() interface {
+	libdns.RecordGetter
+	libdns.RecordAppender
+	libdns.RecordSetter
+	libdns.RecordDeleter
+} {
+	return remoteLibdns{u.remote, u.name}
+}
+
+type remoteLibdns struct {
+	remote
+	name string
+}
+
+func (r remoteLibdns) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	return nil, errors.NewNotSupported(nil, fmt.Sprintf("the %s cannot list records", r.name))
+}
+
+func (r remoteLibdns) SetRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	return nil, errors.NewNotSupported(nil, fmt.Sprintf("the %s cannot set records, only append and delete them", r.name))
+}
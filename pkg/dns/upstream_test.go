@@ -0,0 +1,56 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/client"
+)
+
+func TestUpstreamProvider(t *testing.T) {
+	type upstreamRequest struct {
+		path      string
+		requestID string
+		payload   map[string]string
+	}
+	var mu sync.Mutex
+	var requests []upstreamRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := map[string]string{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		mu.Lock()
+		requests = append(requests, upstreamRequest{r.URL.Path, r.Header.Get(client.RequestIDHeader), payload})
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	p := NewUpstreamProvider(&client.Client{Server: srv.URL, Token: "dc1"}, nil)
+	ctx := ContextWithRequestID(context.Background(), "request-1")
+	err := p.Present(ctx, Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "value", Principal: "*.domain.example", Lifetime: 10 * time.Minute})
+	assert.NoError(t, err)
+	pending := listPending(t, p)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, "_acme-challenge.a.domain.example.", pending[0].RecordName)
+
+	ctx = ContextWithRequestID(context.Background(), "request-2")
+	err = p.Cleanup(ctx, Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "value"})
+	assert.NoError(t, err)
+	assert.Empty(t, listPending(t, p))
+
+	assert.Equal(t, []upstreamRequest{{
+		path:      "/present",
+		requestID: "request-1",
+		payload:   map[string]string{"fqdn": "_acme-challenge.a.domain.example.", "value": "value", "lifetime": "10m0s"},
+	}, {
+		path:      "/cleanup",
+		requestID: "request-2",
+		payload:   map[string]string{"fqdn": "_acme-challenge.a.domain.example.", "value": "value"},
+	}}, requests)
+}
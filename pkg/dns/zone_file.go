@@ -0,0 +1,453 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/libdns/libdns"
+	miekgdns "github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// ZoneFile is a zone file on disk that is served by an authoritative
+// nameserver.
+type ZoneFile struct {
+	// Origin is the name of the zone.
+	Origin string
+	// Path is the zone file.
+	Path string
+	// ReloadCommand is run after the file changed, e.g.
+	// ["nsd-control", "reload", "domain.example"]. Optional.
+	ReloadCommand []string
+}
+
+// zoneFileMarker ends the lines acmep adds to zone files. Only these lines
+// are ever removed again.
+const zoneFileMarker = "; acmep"
+
+// ZoneFileProvider is a libdns provider that edits zone files, for
+// nameservers without dynamic updates. Only TXT records can be changed.
+// They are added as lines ending in "; acmep" at the end of the file, and
+// only those lines are removed again, so the rest of the file is kept as it
+// is apart from the SOA serial, which is bumped on every change. Zone files
+// using $INCLUDE are not supported.
+type ZoneFileProvider struct {
+	Log   *logrus.Logger
+	Zones []ZoneFile
+	// ReloadTimeout bounds the reload command, DefaultExecTimeout when
+	// zero.
+	ReloadTimeout time.Duration
+
+	mu sync.Mutex
+}
+
+// GetRecords returns the records in the zone file of zone.
+func (z *ZoneFileProvider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	zf, err := z.zoneFile(zone)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(zf.Path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	rrs, err := parseZone(zf, data)
+	if err != nil {
+		return nil, err
+	}
+	var records []libdns.Record
+	for _, rr := range rrs {
+		records = append(records, toLibdnsRecord(rr, zf.Origin))
+	}
+	return records, nil
+}
+
+// AppendRecords adds the TXT records recs to the zone file of zone.
+func (z *ZoneFileProvider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	return z.update(ctx, zone, recs, func(lines []string, add []miekgdns.RR) ([]string, []miekgdns.RR) {
+		for _, rr := range add {
+			lines = append(lines, rr.String()+" "+zoneFileMarker+"\n")
+		}
+		return lines, add
+	})
+}
+
+// SetRecords replaces the TXT records acmep added with the names of recs in
+// the zone file of zone with recs.
+func (z *ZoneFileProvider) SetRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	return z.update(ctx, zone, recs, func(lines []string, set []miekgdns.RR) ([]string, []miekgdns.RR) {
+		lines, _ = removeMarkedTXT(lines, func(rr miekgdns.RR) bool {
+			for _, s := range set {
+				if strings.EqualFold(rr.Header().Name, s.Header().Name) {
+					return true
+				}
+			}
+			return false
+		})
+		for _, rr := range set {
+			lines = append(lines, rr.String()+" "+zoneFileMarker+"\n")
+		}
+		return lines, set
+	})
+}
+
+// DeleteRecords deletes the TXT records acmep added matching the name and
+// value of recs from the zone file of zone. The value is ignored when it is
+// empty.
+func (z *ZoneFileProvider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	return z.update(ctx, zone, recs, func(lines []string, del []miekgdns.RR) ([]string, []miekgdns.RR) {
+		return removeMarkedTXT(lines, func(rr miekgdns.RR) bool {
+			for _, d := range del {
+				if strings.EqualFold(rr.Header().Name, d.Header().Name) &&
+					(len(d.(*miekgdns.TXT).Txt) == 0 || txtValue(rr) == txtValue(d)) {
+					return true
+				}
+			}
+			return false
+		})
+	})
+}
+
+// ListZones lists the configured zones, implementing ZoneLister.
+func (z *ZoneFileProvider) ListZones(ctx context.Context) ([]Zone, error) {
+	var zones []Zone
+	for _, zf := range z.Zones {
+		zones = append(zones, Zone{Name: normalizeZone(zf.Origin)})
+	}
+	return zones, nil
+}
+
+// update applies change to the lines of the zone file of zone. change gets
+// the lines, including their line endings, and recs as TXT records and
+// returns the new lines and the changed records. If anything changed, the
+// SOA serial is bumped, the file replaced and the zone reloaded. The old
+// file is put back if the reload fails.
+func (z *ZoneFileProvider) update(ctx context.Context, zone string, recs []libdns.Record, change func(lines []string, recs []miekgdns.RR) ([]string, []miekgdns.RR)) ([]libdns.Record, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	zf, err := z.zoneFile(zone)
+	if err != nil {
+		return nil, err
+	}
+	var txts []miekgdns.RR
+	for _, rec := range recs {
+		if rec.Type != "TXT" {
+			return nil, errors.NotSupportedf("record type %q", rec.Type)
+		}
+		txts = append(txts, toTXT(rec, zf.Origin))
+	}
+
+	old, err := os.ReadFile(zf.Path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	rrs, err := parseZone(zf, old)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(old), "\n")
+	if last := len(lines) - 1; lines[last] == "" {
+		lines = lines[:last]
+	} else {
+		lines[last] += "\n"
+	}
+	lines, changed := change(lines, txts)
+	var res []libdns.Record
+	for _, rr := range changed {
+		res = append(res, toLibdnsRecord(rr, zf.Origin))
+	}
+	if len(changed) == 0 {
+		return res, nil
+	}
+
+	serial, err := soaSerial(rrs)
+	if err != nil {
+		return nil, fmt.Errorf("zone file %s: %w", zf.Path, err)
+	}
+	data, err := replaceSerial(zf, []byte(strings.Join(lines, "")), nextSerial(serial, time.Now()))
+	if err != nil {
+		return nil, fmt.Errorf("zone file %s: %w", zf.Path, err)
+	}
+	err = writeZoneFile(zf, data)
+	if err != nil {
+		return nil, err
+	}
+	err = z.reload(ctx, zf)
+	if err != nil {
+		restoreErr := writeZoneFile(zf, old)
+		if restoreErr != nil {
+			return nil, fmt.Errorf("%w, restoring the zone file failed: %v", err, restoreErr)
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+func (z *ZoneFileProvider) zoneFile(zone string) (ZoneFile, error) {
+	for _, zf := range z.Zones {
+		if normalizeZone(zf.Origin) == normalizeZone(zone) {
+			zf.Origin = normalizeZone(zf.Origin)
+			return zf, nil
+		}
+	}
+	return ZoneFile{}, errors.NotFoundf("zone file for %q", zone)
+}
+
+func (z *ZoneFileProvider) reload(ctx context.Context, zf ZoneFile) error {
+	if len(zf.ReloadCommand) == 0 {
+		return nil
+	}
+	timeout := z.ReloadTimeout
+	if timeout <= 0 {
+		timeout = DefaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, zf.ReloadCommand[0], zf.ReloadCommand[1:]...)
+	out, err := cmd.CombinedOutput()
+	log := z.Log.WithFields(logrus.Fields{
+		"zone":   zf.Origin,
+		"reqID":  RequestIDFromContext(ctx),
+		"reload": zf.ReloadCommand[0],
+	})
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line != "" {
+			log.Info(line)
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("reloading zone %s: %s timed out after %s", zf.Origin, zf.ReloadCommand[0], timeout)
+	} else if err != nil {
+		return fmt.Errorf("reloading zone %s: %s: %w", zf.Origin, zf.ReloadCommand[0], err)
+	}
+	return nil
+}
+
+// removeMarkedTXT removes the TXT records acmep added for which remove
+// returns true from lines, and returns the remaining lines and the removed
+// records.
+func removeMarkedTXT(lines []string, remove func(miekgdns.RR) bool) ([]string, []miekgdns.RR) {
+	var kept []string
+	var removed []miekgdns.RR
+	for _, line := range lines {
+		if strings.HasSuffix(strings.TrimRight(line, "\r\n"), zoneFileMarker) {
+			rr, err := miekgdns.NewRR(line)
+			if err == nil && rr != nil && rr.Header().Rrtype == miekgdns.TypeTXT && remove(rr) {
+				removed = append(removed, rr)
+				continue
+			}
+		}
+		kept = append(kept, line)
+	}
+	return kept, removed
+}
+
+// includeDirective matches $INCLUDE directives at the start of a line.
+var includeDirective = regexp.MustCompile(`(?im)^\$INCLUDE\b`)
+
+func parseZone(zf ZoneFile, data []byte) ([]miekgdns.RR, error) {
+	if includeDirective.Match(data) {
+		return nil, fmt.Errorf("zone file %s: $INCLUDE is not supported", zf.Path)
+	}
+	var rrs []miekgdns.RR
+	zp := miekgdns.NewZoneParser(bytes.NewReader(data), zf.Origin, zf.Path)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("parsing zone file %s: %w", zf.Path, err)
+	}
+	return rrs, nil
+}
+
+// writeZoneFile replaces the zone file with data. The file is written next
+// to it first and then renamed, so the nameserver never reads a partial
+// file.
+func writeZoneFile(zf ZoneFile, data []byte) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(zf.Path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(zf.Path), "."+filepath.Base(zf.Path)+".tmp")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing zone file %s: %w", zf.Path, err)
+	}
+	err = os.Rename(f.Name(), zf.Path)
+	if err != nil {
+		return fmt.Errorf("writing zone file %s: %w", zf.Path, err)
+	}
+	return nil
+}
+
+func soaSerial(rrs []miekgdns.RR) (uint32, error) {
+	for _, rr := range rrs {
+		if soa, ok := rr.(*miekgdns.SOA); ok {
+			return soa.Serial, nil
+		}
+	}
+	return 0, fmt.Errorf("no SOA record")
+}
+
+// nextSerial returns the serial after serial. Serials in the YYYYMMDDnn
+// format move to today's date if they are older.
+func nextSerial(serial uint32, now time.Time) uint32 {
+	next := serial + 1
+	if isDateSerial(serial) {
+		today, _ := strconv.ParseUint(now.UTC().Format("20060102")+"00", 10, 32)
+		if uint32(today) > next {
+			next = uint32(today)
+		}
+	}
+	return next
+}
+
+// replaceSerial replaces the serial of the first SOA record in data, leaving
+// the rest of the text as it is.
+func replaceSerial(zf ZoneFile, data []byte, serial uint32) ([]byte, error) {
+	start, end := soaSerialOffsets(data)
+	if start < 0 {
+		return nil, fmt.Errorf("cannot find the SOA serial")
+	}
+	res := append([]byte(nil), data[:start]...)
+	res = append(res, strconv.FormatUint(uint64(serial), 10)...)
+	res = append(res, data[end:]...)
+
+	// Check the right number was replaced.
+	rrs, err := parseZone(zf, res)
+	if err != nil {
+		return nil, err
+	}
+	got, err := soaSerial(rrs)
+	if err != nil || got != serial {
+		return nil, fmt.Errorf("cannot find the SOA serial")
+	}
+	return res, nil
+}
+
+// soaSerialOffsets returns the offsets of the third field after the first
+// SOA token in data, which is the serial, or -1 if there is none. Comments,
+// quoted strings and parentheses are skipped.
+func soaSerialOffsets(data []byte) (int, int) {
+	fields := -1
+	for i := 0; i < len(data); {
+		switch c := data[i]; {
+		case c == ';':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '(' || c == ')':
+			i++
+		case c == '"':
+			for i++; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+			i++
+			if fields >= 0 {
+				fields++
+			}
+		default:
+			start := i
+			for ; i < len(data) && !strings.ContainsRune(" \t\r\n();\"", rune(data[i])); i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+			if i > len(data) {
+				i = len(data)
+			}
+			switch {
+			case fields < 0 && strings.EqualFold(string(data[start:i]), "SOA"):
+				fields = 0
+			case fields >= 0:
+				fields++
+				if fields == 3 {
+					return start, i
+				}
+			}
+		}
+	}
+	return -1, -1
+}
+
+// isDateSerial reports whether serial looks like YYYYMMDDnn.
+func isDateSerial(serial uint32) bool {
+	s := strconv.FormatUint(uint64(serial), 10)
+	if len(s) != 10 {
+		return false
+	}
+	_, err := time.Parse("20060102", s[:8])
+	return err == nil
+}
+
+// toTXT converts rec to a TXT record in the zone origin. Values longer
+// than 255 bytes are split into several strings.
+func toTXT(rec libdns.Record, origin string) miekgdns.RR {
+	ttl := rec.TTL
+	if ttl <= 0 {
+		ttl = 60 * time.Second
+	}
+	txt := &miekgdns.TXT{
+		Hdr: miekgdns.RR_Header{
+			Name:   miekgdns.Fqdn(libdns.AbsoluteName(rec.Name, origin)),
+			Rrtype: miekgdns.TypeTXT,
+			Class:  miekgdns.ClassINET,
+			Ttl:    uint32(ttl / time.Second),
+		},
+	}
+	value := strings.Trim(rec.Value, `"`)
+	for len(value) > 255 {
+		txt.Txt = append(txt.Txt, value[:255])
+		value = value[255:]
+	}
+	if value != "" {
+		txt.Txt = append(txt.Txt, value)
+	}
+	return txt
+}
+
+func txtValue(rr miekgdns.RR) string {
+	return strings.Join(rr.(*miekgdns.TXT).Txt, "")
+}
+
+func toLibdnsRecord(rr miekgdns.RR, origin string) libdns.Record {
+	hdr := rr.Header()
+	rec := libdns.Record{
+		Type: miekgdns.TypeToString[hdr.Rrtype],
+		Name: libdns.RelativeName(hdr.Name, origin),
+		TTL:  time.Duration(hdr.Ttl) * time.Second,
+	}
+	if hdr.Rrtype == miekgdns.TypeTXT {
+		rec.Value = txtValue(rr)
+	} else {
+		rec.Value = strings.TrimPrefix(rr.String(), hdr.String())
+	}
+	return rec
+}
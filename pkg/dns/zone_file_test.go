@@ -0,0 +1,174 @@
+package dns
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	miekgdns "github.com/miekg/dns"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+const testZoneFile = `
+$ORIGIN domain.example.
+$TTL 300
+@	IN SOA ns1 hostmaster 41 3600 600 86400 60 ; comment
+	IN NS ns1
+ns1	IN A 192.0.2.1
+www	IN TXT "unrelated"
+`
+
+func readSerial(t *testing.T, path string) uint32 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrs, err := parseZone(ZoneFile{Origin: "domain.example.", Path: path}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rrs[0].(*miekgdns.SOA).Serial
+}
+
+func TestZoneFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "domain.example.zone")
+	assert.NoError(t, os.WriteFile(path, []byte(testZoneFile[1:]), 0640))
+	reloads := filepath.Join(dir, "reloads")
+	script := writeScript(t, `echo "$@" >> `+reloads+"\necho reloaded\n")
+
+	log, hook := logtest.NewNullLogger()
+	underlying := &ZoneFileProvider{Log: log, Zones: []ZoneFile{{
+		Origin:        "domain.example",
+		Path:          path,
+		ReloadCommand: []string{script, "reload", "domain.example"},
+	}}}
+	p, err := NewProvider(underlying, (&ListedZoneResolver{Lister: underlying}).Resolve, nil)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, p.Present(ctx, Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "value-a"}))
+	assert.NoError(t, p.Present(ctx, Challenge{FQDN: "b.domain.example.", EncodedKeyAuth: "value-b"}))
+	assert.Equal(t, uint32(43), readSerial(t, path))
+	records, err := underlying.GetRecords(ctx, "domain.example.")
+	assert.NoError(t, err)
+	assert.Contains(t, records, libdns.Record{Type: "TXT", Name: "_acme-challenge.a", Value: "value-a", TTL: time.Minute})
+	assert.Contains(t, records, libdns.Record{Type: "TXT", Name: "www", Value: "unrelated", TTL: 300 * time.Second})
+	assert.Contains(t, records, libdns.Record{Type: "A", Name: "ns1", Value: "192.0.2.1", TTL: 300 * time.Second})
+	assert.Equal(t, "reloaded", hook.LastEntry().Message)
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+
+	assert.NoError(t, p.Cleanup(ctx, Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "value-a"}))
+	assert.Equal(t, uint32(44), readSerial(t, path))
+	records, _ = underlying.GetRecords(ctx, "domain.example.")
+	assert.Len(t, records, 5)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "value-a")
+	// Only the serial changed in the original text.
+	assert.True(t, strings.HasPrefix(string(data), strings.Replace(testZoneFile[1:], " 41 ", " 44 ", 1)), string(data))
+	assert.Equal(t, "_acme-challenge.b.domain.example.\t60\tIN\tTXT\t\"value-b\" ; acmep\n", string(data[len(testZoneFile)-1:]))
+
+	data, err = os.ReadFile(reloads)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("reload domain.example\n", 3), string(data))
+
+	// Deleting a record that isn't there leaves the file alone.
+	deleted, err := underlying.DeleteRecords(ctx, "domain.example.", []libdns.Record{{Type: "TXT", Name: "_acme-challenge.a", Value: "value-a"}})
+	assert.NoError(t, err)
+	assert.Empty(t, deleted)
+	assert.Equal(t, uint32(44), readSerial(t, path))
+
+	_, err = underlying.AppendRecords(ctx, "domain.example.", []libdns.Record{{Type: "A", Name: "www", Value: "192.0.2.2"}})
+	assert.EqualError(t, err, `record type "A" not supported`)
+	_, err = underlying.AppendRecords(ctx, "other.example.", []libdns.Record{{Type: "TXT", Name: "x", Value: "v"}})
+	assert.EqualError(t, err, `zone file for "other.example." not found`)
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestZoneFileProviderReloadFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domain.example.zone")
+	assert.NoError(t, os.WriteFile(path, []byte(testZoneFile[1:]), 0644))
+	log, _ := logtest.NewNullLogger()
+	underlying := &ZoneFileProvider{Log: log, Zones: []ZoneFile{{
+		Origin:        "domain.example.",
+		Path:          path,
+		ReloadCommand: []string{writeScript(t, "exit 1\n")},
+	}}}
+	_, err := underlying.AppendRecords(context.Background(), "domain.example.", []libdns.Record{{Type: "TXT", Name: "_acme-challenge", Value: "v"}})
+	assert.ErrorContains(t, err, "reloading zone domain.example.: ")
+	assert.ErrorContains(t, err, "exit status 1")
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, testZoneFile[1:], string(data))
+}
+
+func TestZoneFileProviderUnmarkedRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domain.example.zone")
+	zone := testZoneFile[1:] + "_acme-challenge IN TXT \"manual\"\n"
+	assert.NoError(t, os.WriteFile(path, []byte(zone), 0644))
+	log, _ := logtest.NewNullLogger()
+	underlying := &ZoneFileProvider{Log: log, Zones: []ZoneFile{{Origin: "domain.example.", Path: path}}}
+	deleted, err := underlying.DeleteRecords(context.Background(), "domain.example.", []libdns.Record{{Type: "TXT", Name: "_acme-challenge"}})
+	assert.NoError(t, err)
+	assert.Empty(t, deleted)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, zone, string(data))
+}
+
+func TestZoneFileProviderInclude(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domain.example.zone")
+	assert.NoError(t, os.WriteFile(path, []byte(testZoneFile[1:]+"$INCLUDE other.zone\n"), 0644))
+	log, _ := logtest.NewNullLogger()
+	underlying := &ZoneFileProvider{Log: log, Zones: []ZoneFile{{Origin: "domain.example.", Path: path}}}
+	_, err := underlying.AppendRecords(context.Background(), "domain.example.", []libdns.Record{{Type: "TXT", Name: "_acme-challenge", Value: "v"}})
+	assert.EqualError(t, err, "zone file "+path+": $INCLUDE is not supported")
+}
+
+func TestReplaceSerial(t *testing.T) {
+	zf := ZoneFile{Origin: "domain.example."}
+	testCases := []struct {
+		zone     string
+		expected string
+	}{{
+		zone:     "@ IN SOA ns1 hostmaster 41 3600 600 86400 60\n",
+		expected: "@ IN SOA ns1 hostmaster 42 3600 600 86400 60\n",
+	}, {
+		zone:     "; SOA 1 2 3\n@ IN SOA ns1 hostmaster (\n\t41 ; serial\n\t3600 600 86400 60 )\n",
+		expected: "; SOA 1 2 3\n@ IN SOA ns1 hostmaster (\n\t42 ; serial\n\t3600 600 86400 60 )\n",
+	}}
+	for _, test := range testCases {
+		data, err := replaceSerial(zf, []byte(test.zone), 42)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, string(data))
+	}
+	_, err := replaceSerial(zf, []byte("ns1 IN A 192.0.2.1\n"), 42)
+	assert.EqualError(t, err, "cannot find the SOA serial")
+}
+
+func TestNextSerial(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		serial   uint32
+		expected uint32
+	}{
+		{serial: 41, expected: 42},
+		{serial: 2026101805, expected: 2026101900},
+		{serial: 2026101905, expected: 2026101906},
+		{serial: 2026102000, expected: 2026102001},
+		{serial: 4294967295, expected: 0},
+	}
+	for _, test := range testCases {
+		assert.Equal(t, test.expected, nextSerial(test.serial, now), "serial %d", test.serial)
+	}
+}
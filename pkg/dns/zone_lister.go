@@ -0,0 +1,126 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns01"
+)
+
+const (
+	// DefaultZoneListRefresh is how long a ListedZoneResolver uses a zone
+	// list before listing the zones again.
+	DefaultZoneListRefresh = 15 * time.Minute
+	// zoneListTimeout bounds a single ListZones call.
+	zoneListTimeout = 30 * time.Second
+)
+
+// Zone is a DNS zone managed by a provider.
+type Zone struct {
+	Name string
+}
+
+// ZoneLister is implemented by providers that can list the zones they
+// manage. It matches the libdns.ZoneLister interface of newer libdns
+// versions.
+type ZoneLister interface {
+	ListZones(ctx context.Context) ([]Zone, error)
+}
+
+// ListedZoneResolver resolves the zone of an FQDN to the longest matching
+// zone listed by Lister. This avoids public SOA lookups, which return the
+// wrong zone in split-horizon setups.
+type ListedZoneResolver struct {
+	Lister ZoneLister
+	// Refresh is how long the zone list is used before it is listed
+	// again, DefaultZoneListRefresh when zero.
+	Refresh time.Duration
+	// Fallback resolves FQDNs in none of the listed zones. They are an
+	// error if it is nil.
+	Fallback ZoneResolver
+
+	mu      sync.Mutex
+	zones   []string
+	fetched time.Time
+	// listing is closed when the running ListZones call returns, nil if
+	// there is none.
+	listing chan struct{}
+	// listErr is the error of the last ListZones call.
+	listErr error
+}
+
+// Resolve is a ZoneResolver. If listing the zones fails, the previous
+// list is used until the next refresh. The previous list is also used while
+// the zones are listed again, so only the first call waits for the
+// provider.
+func (r *ListedZoneResolver) Resolve(fqdn string) (string, error) {
+	zones, err := r.listZones()
+	if err != nil {
+		return "", fmt.Errorf("listing zones: %w", err)
+	}
+	if zone := longestZone(zones, fqdn); zone != "" {
+		return zone, nil
+	}
+	if r.Fallback != nil {
+		return r.Fallback(fqdn)
+	}
+	return "", fmt.Errorf("%s is not in any zone of the provider", fqdn)
+}
+
+func (r *ListedZoneResolver) listZones() ([]string, error) {
+	refresh := r.Refresh
+	if refresh == 0 {
+		refresh = DefaultZoneListRefresh
+	}
+
+	r.mu.Lock()
+	if r.zones != nil && (r.listing != nil || time.Since(r.fetched) < refresh) {
+		zones := r.zones
+		r.mu.Unlock()
+		return zones, nil
+	}
+	if listing := r.listing; listing != nil {
+		// Wait for the first list.
+		r.mu.Unlock()
+		<-listing
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.zones == nil {
+			return nil, r.listErr
+		}
+		return r.zones, nil
+	}
+	listing := make(chan struct{})
+	r.listing = listing
+	r.mu.Unlock()
+
+	// The lock isn't held while listing, which can take up to
+	// zoneListTimeout.
+	ctx, cancel := context.WithTimeout(context.Background(), zoneListTimeout)
+	defer cancel()
+	listed, err := r.Lister.ListZones(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer close(listing)
+	r.listing = nil
+	r.listErr = err
+	if err != nil {
+		if r.zones != nil {
+			// Try again on the next refresh.
+			r.fetched = time.Now()
+			return r.zones, nil
+		}
+		return nil, err
+	}
+	zones := make([]string, 0, len(listed))
+	for _, z := range listed {
+		zones = append(zones, dns01.ToFQDN(strings.ToLower(z.Name)))
+	}
+	r.zones = zones
+	r.fetched = time.Now()
+	return zones, nil
+}
@@ -0,0 +1,99 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeZoneLister struct {
+	zones []Zone
+	err   error
+	calls int
+}
+
+func (f *fakeZoneLister) ListZones(ctx context.Context) ([]Zone, error) {
+	f.calls++
+	return f.zones, f.err
+}
+
+// blockingZoneLister lists zones once release is closed.
+type blockingZoneLister struct {
+	zones   []Zone
+	release chan struct{}
+}
+
+func (b *blockingZoneLister) ListZones(ctx context.Context) ([]Zone, error) {
+	<-b.release
+	return b.zones, nil
+}
+
+func TestListedZoneResolverRefreshDoesNotBlock(t *testing.T) {
+	lister := &blockingZoneLister{zones: []Zone{{Name: "new.example."}}, release: make(chan struct{})}
+	r := &ListedZoneResolver{Lister: lister}
+	r.zones = []string{"example.com."}
+	r.fetched = time.Now().Add(-DefaultZoneListRefresh)
+
+	refreshed := make(chan error)
+	go func() {
+		_, err := r.Resolve("_acme-challenge.new.example.")
+		refreshed <- err
+	}()
+	// Other calls use the previous zones while the refresh is blocked.
+	assert.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.listing != nil
+	}, 5*time.Second, time.Millisecond)
+	zone, err := r.Resolve("_acme-challenge.www.example.com.")
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com.", zone)
+
+	close(lister.release)
+	assert.NoError(t, <-refreshed)
+	zone, err = r.Resolve("_acme-challenge.new.example.")
+	assert.NoError(t, err)
+	assert.Equal(t, "new.example.", zone)
+}
+
+func TestListedZoneResolver(t *testing.T) {
+	lister := &fakeZoneLister{zones: []Zone{{Name: "example.com."}, {Name: "internal.example.com"}}}
+	r := &ListedZoneResolver{Lister: lister}
+
+	zone, err := r.Resolve("_acme-challenge.a.internal.example.com.")
+	assert.NoError(t, err)
+	assert.Equal(t, "internal.example.com.", zone)
+	zone, err = r.Resolve("_acme-challenge.www.example.com.")
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com.", zone)
+	_, err = r.Resolve("_acme-challenge.other.example.")
+	assert.ErrorContains(t, err, "_acme-challenge.other.example. is not in any zone of the provider")
+	assert.Equal(t, 1, lister.calls)
+
+	// A failed refresh keeps the previous zones.
+	r.fetched = time.Now().Add(-DefaultZoneListRefresh)
+	lister.zones, lister.err = nil, errors.New("api down")
+	zone, err = r.Resolve("_acme-challenge.www.example.com.")
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com.", zone)
+	assert.Equal(t, 2, lister.calls)
+
+	r.fetched = time.Now().Add(-DefaultZoneListRefresh)
+	lister.zones, lister.err = []Zone{{Name: "other.example."}}, nil
+	zone, err = r.Resolve("_acme-challenge.other.example.")
+	assert.NoError(t, err)
+	assert.Equal(t, "other.example.", zone)
+	assert.Equal(t, 3, lister.calls)
+
+	r = &ListedZoneResolver{
+		Lister: &fakeZoneLister{err: errors.New("api down")},
+		Fallback: func(fqdn string) (string, error) {
+			return "fallback.example.", nil
+		},
+	}
+	_, err = r.Resolve("_acme-challenge.www.example.com.")
+	assert.ErrorContains(t, err, "listing zones: api down")
+}
@@ -1,6 +1,9 @@
 package dns
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/hpidcock/acme-dns-proxy/pkg/dns01"
 )
 
@@ -10,5 +13,37 @@ type ZoneResolver = func(string) (string, error)
 // DefaultZoneResolver determines the authoritative zone for the given fqdn by recursing
 // up the domain labels until the nameserver returns a SOA record in the answer section.
 func DefaultZoneResolver(fqdn string) (string, error) {
-	return dns01.FindZoneByFQDN(fqdn, dns01.RecursiveNameservers(nil)) // TODO: nameserver config
+	return dns01.DefaultResolver.FindZoneByFQDN(fqdn)
+}
+
+// NewStaticZoneResolver returns a ZoneResolver that picks the longest of
+// zones the fqdn is in, without any DNS queries. FQDNs in none of the zones
+// are passed to fallback, or are an error if fallback is nil.
+func NewStaticZoneResolver(zones []string, fallback ZoneResolver) ZoneResolver {
+	normalized := make([]string, len(zones))
+	for i, zone := range zones {
+		normalized[i] = dns01.ToFQDN(strings.ToLower(zone))
+	}
+	return func(fqdn string) (string, error) {
+		if zone := longestZone(normalized, fqdn); zone != "" {
+			return zone, nil
+		}
+		if fallback != nil {
+			return fallback(fqdn)
+		}
+		return "", fmt.Errorf("%s is not in any configured zone", fqdn)
+	}
+}
+
+// longestZone returns the longest of zones, which must be lower case FQDNs,
+// that fqdn is in, or "" if there is none.
+func longestZone(zones []string, fqdn string) string {
+	name := dns01.ToFQDN(strings.ToLower(fqdn))
+	match := ""
+	for _, zone := range zones {
+		if len(zone) > len(match) && (name == zone || strings.HasSuffix(name, "."+zone)) {
+			match = zone
+		}
+	}
+	return match
 }
@@ -0,0 +1,37 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticZoneResolver(t *testing.T) {
+	resolver := NewStaticZoneResolver([]string{"example.com", "sub.Example.com.", "corp.example"}, nil)
+
+	testCases := []struct {
+		fqdn string
+		zone string
+	}{
+		{"_acme-challenge.www.example.com.", "example.com."},
+		{"_acme-challenge.a.sub.example.com.", "sub.example.com."},
+		{"_acme-challenge.SUB.example.com.", "sub.example.com."},
+		{"corp.example.", "corp.example."},
+		{"_acme-challenge.corp.example", "corp.example."},
+	}
+	for _, test := range testCases {
+		zone, err := resolver(test.fqdn)
+		assert.NoError(t, err, test.fqdn)
+		assert.Equal(t, test.zone, zone, test.fqdn)
+	}
+
+	_, err := resolver("_acme-challenge.notexample.com.")
+	assert.ErrorContains(t, err, "_acme-challenge.notexample.com. is not in any configured zone")
+
+	resolver = NewStaticZoneResolver([]string{"example.com"}, func(fqdn string) (string, error) {
+		return "fallback.example.", nil
+	})
+	zone, err := resolver("_acme-challenge.notexample.com.")
+	assert.NoError(t, err)
+	assert.Equal(t, "fallback.example.", zone)
+}
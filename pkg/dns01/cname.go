@@ -0,0 +1,53 @@
+package dns01
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain is the number of CNAMEs ResolveCNAME follows before giving
+// up.
+const maxCNAMEChain = 10
+
+// ResolveCNAME follows the CNAME chain starting at fqdn and returns the
+// final target, or fqdn itself if it is not a CNAME.
+func ResolveCNAME(fqdn string, nameservers []string) (string, error) {
+	return DefaultResolver.resolveCNAME(fqdn, nameservers)
+}
+
+func (r *Resolver) resolveCNAME(fqdn string, nameservers []string) (string, error) {
+	start := ToFQDN(fqdn)
+	fqdn = start
+	seen := map[string]bool{}
+	for i := 0; i <= maxCNAMEChain; i++ {
+		if seen[strings.ToLower(fqdn)] {
+			return "", fmt.Errorf("CNAME loop at %s", fqdn)
+		}
+		seen[strings.ToLower(fqdn)] = true
+
+		in, err := r.dnsQuery(fqdn, dns.TypeCNAME, nameservers, true)
+		if err != nil {
+			return "", fmt.Errorf("could not resolve CNAME for %s: %w", fqdn, err)
+		}
+		if in == nil {
+			return "", fmt.Errorf("could not resolve CNAME for %s: no nameservers", fqdn)
+		}
+		if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+			return "", fmt.Errorf("unexpected response code '%s' for %s", dns.RcodeToString[in.Rcode], fqdn)
+		}
+
+		target := ""
+		for _, rr := range in.Answer {
+			if cn, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cn.Hdr.Name, fqdn) {
+				target = cn.Target
+			}
+		}
+		if target == "" {
+			return fqdn, nil
+		}
+		fqdn = target
+	}
+	return "", fmt.Errorf("CNAME chain starting at %s is longer than %d", start, maxCNAMEChain)
+}
@@ -0,0 +1,37 @@
+package dns01
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveCNAME(t *testing.T) {
+	testCases := []struct {
+		fqdn          string
+		target        string
+		expectedError string
+	}{
+		{fqdn: "www.example.com.", target: "www.example.com."},
+		{fqdn: "missing.example.com.", target: "missing.example.com."},
+		{fqdn: "scholar.example.com", target: "scholar.l.example.com."},
+		{fqdn: "_acme-challenge.alias.example.com.", target: "_acme-challenge.validation.example.net."},
+		{fqdn: "loop-a.example.com.", expectedError: "CNAME loop at loop-a.example.com."},
+	}
+
+	r := &Resolver{Nameservers: []string{testServer.Addr}}
+	for _, test := range testCases {
+		target, err := r.ResolveCNAME(test.fqdn)
+		if test.expectedError != "" {
+			if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+				t.Errorf("%s: expected error %q, got %v", test.fqdn, test.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected no error, got: %v", test.fqdn, err)
+		}
+		if target != test.target {
+			t.Errorf("%s: expected %s but got %s", test.fqdn, test.target, target)
+		}
+	}
+}
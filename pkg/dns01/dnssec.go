@@ -0,0 +1,279 @@
+package dns01
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DNSSECAuthenticatedData requires the recursive nameservers to set the
+	// AD bit on their answers. They must be validating resolvers reached
+	// over a trusted path, such as a local resolver or DNS over TLS.
+	DNSSECAuthenticatedData = "ad"
+	// DNSSECValidate validates answers locally, from the configured trust
+	// anchors down to the signed records.
+	DNSSECValidate = "validate"
+)
+
+// maxDNSSECChain is the number of zones validated above the zone of an
+// answer before giving up.
+const maxDNSSECChain = 16
+
+// RootTrustAnchors are the DS records of the root zone KSKs, used by
+// DNSSECValidate when Resolver.TrustAnchors is empty.
+var RootTrustAnchors = []dns.RR{
+	mustParseRR(". 0 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"),
+	mustParseRR(". 0 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16"),
+}
+
+func mustParseRR(s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		panic(err)
+	}
+	return rr
+}
+
+// errDNSSEC is wrapped by all DNSSEC validation errors.
+var errDNSSEC = errors.New("DNSSEC validation failed")
+
+// validateDNSSEC checks the response in according to r.DNSSEC.
+//
+// With DNSSECValidate only the records in the answer section are
+// validated. Denial of existence is not, so a spoofed NXDOMAIN can still
+// make a lookup fail, but not make it return unsigned records.
+func (r *Resolver) validateDNSSEC(in *dns.Msg, nameservers []string) error {
+	switch r.DNSSEC {
+	case "":
+		return nil
+	case DNSSECAuthenticatedData:
+		if !in.AuthenticatedData {
+			return fmt.Errorf("%w: answer for %s is not authenticated", errDNSSEC, questionName(in))
+		}
+		return nil
+	case DNSSECValidate:
+		v := &dnssecValidator{r: r, nameservers: nameservers, keys: map[string][]*dns.DNSKEY{}}
+		return v.validateAnswer(in)
+	default:
+		return fmt.Errorf("unsupported DNSSEC mode %q", r.DNSSEC)
+	}
+}
+
+func questionName(in *dns.Msg) string {
+	if len(in.Question) == 0 {
+		return "."
+	}
+	return in.Question[0].Name
+}
+
+// dnssecValidator validates the answers of one response, remembering the
+// keys it validated on the way.
+type dnssecValidator struct {
+	r           *Resolver
+	nameservers []string
+	keys        map[string][]*dns.DNSKEY
+}
+
+func (v *dnssecValidator) validateAnswer(in *dns.Msg) error {
+	rrsets, sigs := splitRRsets(in.Answer)
+	for _, rrset := range rrsets {
+		err := v.validateRRset(rrset, sigs, 0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRRset checks that one of sigs over rrset verifies with a
+// validated key of its signer.
+func (v *dnssecValidator) validateRRset(rrset []dns.RR, sigs []*dns.RRSIG, depth int) error {
+	hdr := rrset[0].Header()
+	var lastErr error
+	for _, sig := range sigs {
+		if sig.TypeCovered != hdr.Rrtype || !strings.EqualFold(sig.Hdr.Name, hdr.Name) {
+			continue
+		}
+		if !dns.IsSubDomain(sig.SignerName, hdr.Name) {
+			continue
+		}
+		keys, err := v.zoneKeys(strings.ToLower(sig.SignerName), depth+1)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if verifyRRset(rrset, []*dns.RRSIG{sig}, keys) {
+			return nil
+		}
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	return fmt.Errorf("%w: no valid signature for %s %s", errDNSSEC, hdr.Name, dns.TypeToString[hdr.Rrtype])
+}
+
+// zoneKeys returns the DNSKEYs of zone, after validating them against the
+// trust anchors or the DS records in the parent zone.
+func (v *dnssecValidator) zoneKeys(zone string, depth int) ([]*dns.DNSKEY, error) {
+	if keys, ok := v.keys[zone]; ok {
+		return keys, nil
+	}
+	if depth > maxDNSSECChain {
+		return nil, fmt.Errorf("%w: chain of trust for %s is too long", errDNSSEC, zone)
+	}
+
+	in, err := v.r.rawDNSQuery(zone, dns.TypeDNSKEY, v.nameservers, true)
+	if err != nil {
+		return nil, fmt.Errorf("%w: querying DNSKEY for %s: %v", errDNSSEC, zone, err)
+	}
+	if in == nil {
+		return nil, fmt.Errorf("%w: querying DNSKEY for %s: no nameservers", errDNSSEC, zone)
+	}
+	rrsets, sigs := splitRRsets(in.Answer)
+	var keySet []dns.RR
+	var keys []*dns.DNSKEY
+	for _, rrset := range rrsets {
+		for _, rr := range rrset {
+			if key, ok := rr.(*dns.DNSKEY); ok && strings.EqualFold(key.Hdr.Name, zone) {
+				keySet = append(keySet, key)
+				keys = append(keys, key)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no DNSKEY for %s", errDNSSEC, zone)
+	}
+
+	anchors := v.r.trustAnchors(zone)
+	if len(anchors) == 0 {
+		if zone == "." {
+			return nil, fmt.Errorf("%w: no trust anchor for the root zone", errDNSSEC)
+		}
+		anchors, err = v.delegationSigners(zone, depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var trusted []*dns.DNSKEY
+	for _, key := range keys {
+		if matchesAnchor(key, anchors) {
+			trusted = append(trusted, key)
+		}
+	}
+	if !verifyRRset(keySet, sigs, trusted) {
+		return nil, fmt.Errorf("%w: DNSKEY set of %s is not signed by a trusted key", errDNSSEC, zone)
+	}
+	v.keys[zone] = keys
+	return keys, nil
+}
+
+// delegationSigners returns the validated DS records for zone from its
+// parent.
+func (v *dnssecValidator) delegationSigners(zone string, depth int) ([]dns.RR, error) {
+	in, err := v.r.rawDNSQuery(zone, dns.TypeDS, v.nameservers, true)
+	if err != nil {
+		return nil, fmt.Errorf("%w: querying DS for %s: %v", errDNSSEC, zone, err)
+	}
+	if in == nil {
+		return nil, fmt.Errorf("%w: querying DS for %s: no nameservers", errDNSSEC, zone)
+	}
+	rrsets, sigs := splitRRsets(in.Answer)
+	for _, rrset := range rrsets {
+		if rrset[0].Header().Rrtype != dns.TypeDS || !strings.EqualFold(rrset[0].Header().Name, zone) {
+			continue
+		}
+		err = v.validateRRset(rrset, sigs, depth)
+		if err != nil {
+			return nil, err
+		}
+		return rrset, nil
+	}
+	return nil, fmt.Errorf("%w: %s is not signed", errDNSSEC, zone)
+}
+
+func (r *Resolver) trustAnchors(zone string) []dns.RR {
+	anchors := r.TrustAnchors
+	if len(anchors) == 0 {
+		anchors = RootTrustAnchors
+	}
+	var matching []dns.RR
+	for _, rr := range anchors {
+		if strings.EqualFold(rr.Header().Name, zone) {
+			matching = append(matching, rr)
+		}
+	}
+	return matching
+}
+
+// matchesAnchor reports whether key is one of the DNSKEY anchors or matches
+// one of the DS anchors.
+func matchesAnchor(key *dns.DNSKEY, anchors []dns.RR) bool {
+	for _, anchor := range anchors {
+		switch a := anchor.(type) {
+		case *dns.DS:
+			if a.KeyTag != key.KeyTag() || a.Algorithm != key.Algorithm {
+				continue
+			}
+			ds := key.ToDS(a.DigestType)
+			if ds != nil && strings.EqualFold(ds.Digest, a.Digest) {
+				return true
+			}
+		case *dns.DNSKEY:
+			if a.Flags == key.Flags && a.Protocol == key.Protocol &&
+				a.Algorithm == key.Algorithm && a.PublicKey == key.PublicKey {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyRRset reports whether one of sigs over rrset is currently valid and
+// verifies with one of keys.
+func verifyRRset(rrset []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY) bool {
+	hdr := rrset[0].Header()
+	now := time.Now()
+	for _, sig := range sigs {
+		if sig.TypeCovered != hdr.Rrtype || !strings.EqualFold(sig.Hdr.Name, hdr.Name) {
+			continue
+		}
+		if !sig.ValidityPeriod(now) {
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() == sig.KeyTag && sig.Verify(key, rrset) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitRRsets groups rrs into RRsets by name and type, and returns the
+// RRSIGs separately.
+func splitRRsets(rrs []dns.RR) ([][]dns.RR, []*dns.RRSIG) {
+	var rrsets [][]dns.RR
+	var sigs []*dns.RRSIG
+	index := map[string]int{}
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs = append(sigs, sig)
+			continue
+		}
+		hdr := rr.Header()
+		key := strings.ToLower(hdr.Name) + "/" + dns.TypeToString[hdr.Rrtype]
+		i, ok := index[key]
+		if !ok {
+			i = len(rrsets)
+			index[key] = i
+			rrsets = append(rrsets, nil)
+		}
+		rrsets[i] = append(rrsets[i], rr)
+	}
+	return rrsets, sigs
+}
@@ -0,0 +1,159 @@
+package dns01
+
+import (
+	"crypto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/dnstest"
+)
+
+type signedZone struct {
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newSignedZone(t *testing.T, name string) *signedZone {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 300},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &signedZone{key: key, priv: priv.(crypto.Signer)}
+}
+
+func (z *signedZone) sign(t *testing.T, rrset ...dns.RR) []dns.RR {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 300},
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.key.Hdr.Name,
+		Algorithm:  z.key.Algorithm,
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+	}
+	err := sig.Sign(z.priv, rrset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(rrset, sig)
+}
+
+func newSOA(name string) *dns.SOA {
+	return &dns.SOA{
+		Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+		Ns:     "ns1." + name,
+		Mbox:   "hostmaster." + name,
+		Minttl: 60,
+	}
+}
+
+// newDNSSECServer serves a root zone delegating to the signed zone
+// example.com. and the unsigned zone unsigned.example., and returns its
+// address and the root trust anchor. forged.example.com. has an SOA record
+// whose signature doesn't match.
+func newDNSSECServer(t *testing.T, setAD bool) (string, []dns.RR) {
+	root := newSignedZone(t, ".")
+	example := newSignedZone(t, "example.com.")
+
+	forged := newSOA("forged.example.com.")
+	forgedSigned := example.sign(t, forged)
+	forgedSigned[0] = newSOA("forged.example.com.")
+	forgedSigned[0].(*dns.SOA).Ns = "ns.attacker.example."
+
+	answers := map[string][]dns.RR{
+		"./DNSKEY":                root.sign(t, root.key),
+		"example.com./DS":         root.sign(t, example.key.ToDS(dns.SHA256)),
+		"example.com./DNSKEY":     example.sign(t, example.key),
+		"example.com./SOA":        example.sign(t, newSOA("example.com.")),
+		"forged.example.com./SOA": forgedSigned,
+		"unsigned.example./SOA":   {newSOA("unsigned.example.")},
+	}
+
+	server := dnstest.NewServer(dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		q := req.Question[0]
+		rrs, ok := answers[strings.ToLower(q.Name)+"/"+dns.TypeToString[q.Qtype]]
+		if ok {
+			m.Answer = rrs
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		m.AuthenticatedData = setAD
+		_ = w.WriteMsg(m)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.Addr, []dns.RR{root.key.ToDS(dns.SHA256)}
+}
+
+func TestResolverDNSSECValidate(t *testing.T) {
+	addr, anchors := newDNSSECServer(t, false)
+
+	r := &Resolver{Nameservers: []string{addr}, DNSSEC: DNSSECValidate, TrustAnchors: anchors}
+	zone, err := r.FindZoneByFQDN("_acme-challenge.www.example.com.")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if zone != "example.com." {
+		t.Errorf("expected zone example.com., got %s", zone)
+	}
+
+	testCases := []struct {
+		fqdn          string
+		anchors       []dns.RR
+		expectedError string
+	}{
+		{
+			fqdn:          "_acme-challenge.forged.example.com.",
+			anchors:       anchors,
+			expectedError: "DNSSEC validation failed: no valid signature for forged.example.com. SOA",
+		},
+		{
+			// must not fall back to the signed parent zone
+			fqdn:          "_acme-challenge.unsigned.example.",
+			anchors:       anchors,
+			expectedError: "DNSSEC validation failed: no valid signature for unsigned.example. SOA",
+		},
+		{
+			fqdn:          "_acme-challenge.www.example.com.",
+			anchors:       RootTrustAnchors,
+			expectedError: "DNSSEC validation failed: DNSKEY set of . is not signed by a trusted key",
+		},
+	}
+	for _, test := range testCases {
+		r := &Resolver{Nameservers: []string{addr}, DNSSEC: DNSSECValidate, TrustAnchors: test.anchors}
+		_, err := r.FindZoneByFQDN(test.fqdn)
+		if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+			t.Errorf("%s: expected error %q, got %v", test.fqdn, test.expectedError, err)
+		}
+	}
+}
+
+func TestResolverDNSSECAuthenticatedData(t *testing.T) {
+	addr, _ := newDNSSECServer(t, true)
+	r := &Resolver{Nameservers: []string{addr}, DNSSEC: DNSSECAuthenticatedData}
+	zone, err := r.FindZoneByFQDN("_acme-challenge.unsigned.example.")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if zone != "unsigned.example." {
+		t.Errorf("expected zone unsigned.example., got %s", zone)
+	}
+
+	addr, _ = newDNSSECServer(t, false)
+	r = &Resolver{Nameservers: []string{addr}, DNSSEC: DNSSECAuthenticatedData}
+	_, err = r.FindZoneByFQDN("_acme-challenge.www.example.com.")
+	expected := "DNSSEC validation failed: answer for _acme-challenge.www.example.com. is not authenticated"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
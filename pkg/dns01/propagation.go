@@ -0,0 +1,102 @@
+package dns01
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Code in this file adapted from go-acme/lego, July 2020:
+// https://github.com/go-acme/lego
+// by Ludovic Fernandez and Dominik Menke
+// modified by https://github.com/caddyserver/certmagic
+//
+// It has been modified.
+
+// CheckDNSPropagation reports whether every authoritative nameserver for
+// fqdn serves a TXT record with the given value. CNAMEs at fqdn are
+// followed once.
+func CheckDNSPropagation(fqdn, value string, resolvers []string) (bool, error) {
+	return DefaultResolver.checkDNSPropagation(fqdn, value, resolvers)
+}
+
+func (res *Resolver) checkDNSPropagation(fqdn, value string, resolvers []string) (bool, error) {
+	fqdn = ToFQDN(fqdn)
+
+	// Initial attempt to resolve at the recursive NS
+	r, err := res.dnsQuery(fqdn, dns.TypeTXT, resolvers, true)
+	if err != nil {
+		return false, err
+	}
+	if r.Rcode == dns.RcodeSuccess {
+		fqdn = updateDomainWithCName(r, fqdn)
+	}
+
+	authoritativeNss := res.AuthoritativeNameservers
+	if len(authoritativeNss) > 0 {
+		authoritativeNss = append([]string(nil), authoritativeNss...)
+		populateNameserverPorts(authoritativeNss)
+	} else {
+		names, err := res.lookupNameservers(fqdn, resolvers)
+		if err != nil {
+			return false, err
+		}
+		for _, ns := range names {
+			authoritativeNss = append(authoritativeNss, net.JoinHostPort(ns, "53"))
+		}
+	}
+
+	return res.checkAuthoritativeNss(fqdn, value, authoritativeNss)
+}
+
+// checkAuthoritativeNss queries each of the given nameserver addresses for the expected TXT record.
+func (res *Resolver) checkAuthoritativeNss(fqdn, value string, nameservers []string) (bool, error) {
+	for _, ns := range nameservers {
+		r, err := res.dnsQuery(fqdn, dns.TypeTXT, []string{ns}, false)
+		if err != nil {
+			return false, err
+		}
+
+		if r.Rcode != dns.RcodeSuccess {
+			if r.Rcode == dns.RcodeNameError {
+				// if Present() succeeded, then it must show up eventually, or else
+				// something is really broken in the DNS provider or their API;
+				// no need for error here, simply have the caller try again
+				return false, nil
+			}
+			return false, fmt.Errorf("NS %s returned %s for %s", ns, dns.RcodeToString[r.Rcode], fqdn)
+		}
+
+		var found bool
+		for _, rr := range r.Answer {
+			if txt, ok := rr.(*dns.TXT); ok {
+				record := strings.Join(txt.Txt, "")
+				if record == value {
+					found = true
+					break
+				}
+			}
+		}
+
+		if !found {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// updateDomainWithCName returns the CNAME target of fqdn in r, or fqdn if
+// there is none.
+func updateDomainWithCName(r *dns.Msg, fqdn string) string {
+	for _, rr := range r.Answer {
+		if cn, ok := rr.(*dns.CNAME); ok {
+			if cn.Hdr.Name == fqdn {
+				return cn.Target
+			}
+		}
+	}
+	return fqdn
+}
@@ -0,0 +1,53 @@
+package dns01
+
+import (
+	"testing"
+)
+
+func TestCheckDNSPropagation(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		fqdn     string
+		value    string
+		expected bool
+	}{
+		{
+			desc:     "record present",
+			fqdn:     "_acme-challenge.www.example.com.",
+			value:    "token",
+			expected: true,
+		},
+		{
+			desc:  "other value",
+			fqdn:  "_acme-challenge.www.example.com.",
+			value: "other",
+		},
+		{
+			desc:  "record missing",
+			fqdn:  "_acme-challenge.missing.example.com.",
+			value: "token",
+		},
+		{
+			desc:     "CNAME to another zone",
+			fqdn:     "_acme-challenge.alias.example.com.",
+			value:    "delegated",
+			expected: true,
+		},
+	}
+
+	r := &Resolver{
+		Nameservers:              []string{testServer.Addr},
+		AuthoritativeNameservers: []string{testServer.Addr},
+	}
+	for i, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			ok, err := r.CheckDNSPropagation(test.fqdn, test.value)
+			if err != nil {
+				t.Fatalf("test %d: expected no error, got: %v", i, err)
+			}
+			if ok != test.expected {
+				t.Errorf("test %d: expected %v but got %v", i, test.expected, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package dns01
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultTimeout is the timeout of each DNS query when Resolver.Timeout is
+// not set.
+const DefaultTimeout = 10 * time.Second
+
+// DefaultResolver is the Resolver used by the package level functions.
+var DefaultResolver = &Resolver{}
+
+// Resolver sends the DNS queries for zone discovery, CNAME resolution and
+// propagation checks, and caches the SOA records it finds. The zero value
+// uses the system resolvers.
+type Resolver struct {
+	// Nameservers are queried instead of the system resolvers when set.
+	// They may be URLs to use DNS over TLS or HTTPS, see sendDNSQuery.
+	Nameservers []string
+	// Timeout of each query, DefaultTimeout when zero.
+	Timeout time.Duration
+	// AuthoritativeNameservers are queried by propagation checks instead
+	// of the authoritative nameservers listed for the zone, when set.
+	AuthoritativeNameservers []string
+	// TCPOnly sends queries over TCP only, instead of UDP with a TCP
+	// fallback.
+	TCPOnly bool
+	// DNSSEC requires DNSSEC validated answers to recursive queries when
+	// set to DNSSECAuthenticatedData or DNSSECValidate.
+	DNSSEC string
+	// TrustAnchors are the DS or DNSKEY records DNSSECValidate trusts,
+	// RootTrustAnchors when empty.
+	TrustAnchors []dns.RR
+	// TLSConfig is used for DNS over TLS and HTTPS nameservers. The system
+	// roots are trusted when nil.
+	TLSConfig *tls.Config
+	// MinCacheTTL and MaxCacheTTL bound how long SOA records and NXDOMAIN
+	// responses are cached, which is their TTL otherwise. No bound applies
+	// when zero.
+	MinCacheTTL time.Duration
+	MaxCacheTTL time.Duration
+	// CacheSize is the number of lookups cached, DefaultCacheSize when
+	// zero.
+	CacheSize int
+
+	cache soaCache
+
+	httpClientOnce     sync.Once
+	httpClientInstance *http.Client
+}
+
+// FindZoneByFQDN determines the zone apex for the given fqdn.
+func (r *Resolver) FindZoneByFQDN(fqdn string) (string, error) {
+	return r.findZoneByFQDN(fqdn, r.nameservers())
+}
+
+// ResolveCNAME follows the CNAME chain starting at fqdn and returns the
+// final target, or fqdn itself if it is not a CNAME.
+func (r *Resolver) ResolveCNAME(fqdn string) (string, error) {
+	return r.resolveCNAME(fqdn, r.nameservers())
+}
+
+// CheckDNSPropagation reports whether every authoritative nameserver for
+// fqdn serves a TXT record with the given value.
+func (r *Resolver) CheckDNSPropagation(fqdn, value string) (bool, error) {
+	return r.checkDNSPropagation(fqdn, value, r.nameservers())
+}
+
+func (r *Resolver) nameservers() []string {
+	if len(r.Nameservers) == 0 {
+		return RecursiveNameservers(nil)
+	}
+	servers := append([]string(nil), r.Nameservers...)
+	populateNameserverPorts(servers)
+	return servers
+}
+
+func (r *Resolver) timeout() time.Duration {
+	if r.Timeout == 0 {
+		return DefaultTimeout
+	}
+	return r.Timeout
+}
+
+func (r *Resolver) cacheSize() int {
+	if r.CacheSize == 0 {
+		return DefaultCacheSize
+	}
+	return r.CacheSize
+}
+
+// cacheTTL applies MinCacheTTL and MaxCacheTTL to ttl.
+func (r *Resolver) cacheTTL(ttl time.Duration) time.Duration {
+	if r.MinCacheTTL > 0 && ttl < r.MinCacheTTL {
+		ttl = r.MinCacheTTL
+	}
+	if r.MaxCacheTTL > 0 && ttl > r.MaxCacheTTL {
+		ttl = r.MaxCacheTTL
+	}
+	return ttl
+}
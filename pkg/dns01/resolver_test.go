@@ -0,0 +1,38 @@
+package dns01
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolverNameservers(t *testing.T) {
+	r := &Resolver{Nameservers: []string{"10.0.0.1", "10.0.0.2:5353"}}
+	expected := []string{"10.0.0.1:53", "10.0.0.2:5353"}
+	if ns := r.nameservers(); !reflect.DeepEqual(expected, ns) {
+		t.Errorf("expected %v but got %v", expected, ns)
+	}
+	if r.Nameservers[0] != "10.0.0.1" {
+		t.Errorf("expected Nameservers to be left unchanged, got %v", r.Nameservers)
+	}
+}
+
+func TestResolverCacheTTL(t *testing.T) {
+	testCases := []struct {
+		min, max time.Duration
+		ttl      time.Duration
+		expected time.Duration
+	}{
+		{ttl: time.Hour, expected: time.Hour},
+		{min: time.Minute, ttl: time.Second, expected: time.Minute},
+		{max: time.Minute, ttl: time.Hour, expected: time.Minute},
+		{min: time.Second, max: time.Hour, ttl: time.Minute, expected: time.Minute},
+	}
+
+	for i, test := range testCases {
+		r := &Resolver{MinCacheTTL: test.min, MaxCacheTTL: test.max}
+		if ttl := r.cacheTTL(test.ttl); ttl != test.expected {
+			t.Errorf("test %d: expected %v but got %v", i, test.expected, ttl)
+		}
+	}
+}
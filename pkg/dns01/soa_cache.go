@@ -0,0 +1,153 @@
+package dns01
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultCacheSize is the number of SOA lookups a Resolver caches when
+	// Resolver.CacheSize is not set.
+	DefaultCacheSize = 1000
+	// defaultNegativeTTL is how long an NXDOMAIN is cached when the
+	// response has no SOA record to take the negative TTL from.
+	defaultNegativeTTL = time.Minute
+)
+
+// soaCacheEntry holds a cached SOA record (only selected fields), or the
+// error of a lookup that ended in NXDOMAIN.
+type soaCacheEntry struct {
+	zone      string    // zone apex (a domain name)
+	primaryNs string    // primary nameserver for the zone apex
+	err       error     // set for negative entries
+	expires   time.Time // time when this cache entry should be evicted
+}
+
+// isExpired checks whether a cache entry should be considered expired.
+func (cache *soaCacheEntry) isExpired() bool {
+	return time.Now().After(cache.expires)
+}
+
+// soaTTL returns how long the SOA record may be cached.
+func soaTTL(soa *dns.SOA) time.Duration {
+	return time.Duration(soa.Hdr.Ttl) * time.Second
+}
+
+// negativeTTL returns how long the NXDOMAIN response in may be cached, the
+// lower of the TTL and the MINIMUM field of the SOA record in the authority
+// section (RFC 2308).
+func negativeTTL(in *dns.Msg) time.Duration {
+	for _, rr := range in.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := soa.Hdr.Ttl
+			if soa.Minttl < ttl {
+				ttl = soa.Minttl
+			}
+			return time.Duration(ttl) * time.Second
+		}
+	}
+	return defaultNegativeTTL
+}
+
+// soaCache is an LRU cache of SOA lookups. Concurrent lookups of the same
+// key share a single fetch, and the lock is never held while fetching, so a
+// slow nameserver only delays lookups of the same key. The zero value is
+// ready to use.
+type soaCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element // values are *soaCacheItem
+	lru     list.List                // most recently used first
+	calls   map[string]*soaCacheCall
+}
+
+type soaCacheItem struct {
+	key string
+	ent *soaCacheEntry
+}
+
+// soaCacheCall is a fetch in progress. ent and err are set before done is
+// closed.
+type soaCacheCall struct {
+	done chan struct{}
+	ent  *soaCacheEntry
+	err  error
+}
+
+// get returns the cached entry for key, or calls fetch and caches the
+// entry it returns, keeping at most size entries. Errors are cached when
+// fetch returns both an entry and an error.
+func (c *soaCache) get(key string, size int, fetch func() (*soaCacheEntry, error)) (*soaCacheEntry, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		ent := el.Value.(*soaCacheItem).ent
+		if !ent.isExpired() {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			return entryResult(ent)
+		}
+		c.removeLocked(el)
+	}
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.ent, call.err
+	}
+	call := &soaCacheCall{done: make(chan struct{})}
+	if c.calls == nil {
+		c.calls = map[string]*soaCacheCall{}
+	}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	ent, err := fetch()
+	if ent != nil {
+		call.ent, call.err = entryResult(ent)
+	} else {
+		call.err = err
+	}
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	if ent != nil {
+		c.addLocked(key, ent, size)
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.ent, call.err
+}
+
+func entryResult(ent *soaCacheEntry) (*soaCacheEntry, error) {
+	if ent.err != nil {
+		return nil, ent.err
+	}
+	return ent, nil
+}
+
+func (c *soaCache) addLocked(key string, ent *soaCacheEntry, size int) {
+	if c.entries == nil {
+		c.entries = map[string]*list.Element{}
+	}
+	if el, ok := c.entries[key]; ok {
+		c.removeLocked(el)
+	}
+	c.entries[key] = c.lru.PushFront(&soaCacheItem{key: key, ent: ent})
+	for c.lru.Len() > size {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+func (c *soaCache) removeLocked(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*soaCacheItem).key)
+}
+
+// clear removes all cached entries.
+func (c *soaCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+	c.lru.Init()
+}
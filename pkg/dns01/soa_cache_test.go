@@ -0,0 +1,167 @@
+package dns01
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSOACacheSingleflight(t *testing.T) {
+	var c soaCache
+	var fetches int32
+	release := make(chan struct{})
+	fetch := func() (*soaCacheEntry, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return &soaCacheEntry{zone: "example.com.", expires: time.Now().Add(time.Hour)}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ent, err := c.get("a.example.com.", 10, fetch)
+			if err != nil || ent.zone != "example.com." {
+				t.Errorf("expected example.com., got %v, %v", ent, err)
+			}
+		}()
+	}
+
+	// A slow fetch doesn't block other keys.
+	ent, err := c.get("b.example.com.", 10, func() (*soaCacheEntry, error) {
+		return &soaCacheEntry{zone: "b.example.com.", expires: time.Now().Add(time.Hour)}, nil
+	})
+	if err != nil || ent.zone != "b.example.com." {
+		t.Errorf("expected b.example.com., got %v, %v", ent, err)
+	}
+
+	close(release)
+	wg.Wait()
+	if fetches != 1 {
+		t.Errorf("expected 1 fetch, got %d", fetches)
+	}
+}
+
+func TestSOACacheLRU(t *testing.T) {
+	var c soaCache
+	var fetches []string
+	get := func(key string) {
+		_, _ = c.get(key, 2, func() (*soaCacheEntry, error) {
+			fetches = append(fetches, key)
+			return &soaCacheEntry{zone: key, expires: time.Now().Add(time.Hour)}, nil
+		})
+	}
+
+	get("a.")
+	get("b.")
+	get("a.")
+	get("c.") // evicts b.
+	get("a.")
+	get("b.")
+
+	expected := fmt.Sprint([]string{"a.", "b.", "c.", "b."})
+	if fmt.Sprint(fetches) != expected {
+		t.Errorf("expected fetches %s, got %v", expected, fetches)
+	}
+}
+
+func TestSOACacheExpiry(t *testing.T) {
+	var c soaCache
+	fetches := 0
+	fetch := func() (*soaCacheEntry, error) {
+		fetches++
+		return &soaCacheEntry{zone: "example.com.", expires: time.Now().Add(-time.Second)}, nil
+	}
+	_, _ = c.get("a.example.com.", 10, fetch)
+	_, _ = c.get("a.example.com.", 10, fetch)
+	if fetches != 2 {
+		t.Errorf("expected expired entry to be fetched again, got %d fetches", fetches)
+	}
+}
+
+func TestSOACacheNegative(t *testing.T) {
+	var c soaCache
+	fetches := 0
+	nxdomain := errors.New("NXDOMAIN")
+	fetch := func() (*soaCacheEntry, error) {
+		fetches++
+		return &soaCacheEntry{err: nxdomain, expires: time.Now().Add(time.Hour)}, nxdomain
+	}
+	for i := 0; i < 2; i++ {
+		ent, err := c.get("a.invalid.", 10, fetch)
+		if ent != nil || err != nxdomain {
+			t.Errorf("expected NXDOMAIN error, got %v, %v", ent, err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("expected NXDOMAIN to be cached, got %d fetches", fetches)
+	}
+
+	// Other errors aren't cached.
+	fetches = 0
+	fetch = func() (*soaCacheEntry, error) {
+		fetches++
+		return nil, errors.New("timeout")
+	}
+	_, _ = c.get("b.example.", 10, fetch)
+	_, _ = c.get("b.example.", 10, fetch)
+	if fetches != 2 {
+		t.Errorf("expected errors not to be cached, got %d fetches", fetches)
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	in := new(dns.Msg)
+	if ttl := negativeTTL(in); ttl != defaultNegativeTTL {
+		t.Errorf("expected %v without SOA, got %v", defaultNegativeTTL, ttl)
+	}
+	in.Ns = []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Ttl: 900}, Minttl: 300}}
+	if ttl := negativeTTL(in); ttl != 300*time.Second {
+		t.Errorf("expected 5m, got %v", ttl)
+	}
+}
+
+// BenchmarkSOACacheParallelMisses looks up distinct keys in parallel with
+// a fetch that takes a millisecond, like a slow nameserver. Fetches run
+// concurrently, so a lookup takes well under a millisecond on average.
+func BenchmarkSOACacheParallelMisses(b *testing.B) {
+	var c soaCache
+	var n int64
+	b.SetParallelism(16)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := fmt.Sprintf("%d.example.com.", atomic.AddInt64(&n, 1))
+			_, _ = c.get(key, DefaultCacheSize, func() (*soaCacheEntry, error) {
+				time.Sleep(time.Millisecond)
+				return &soaCacheEntry{zone: "example.com.", expires: time.Now().Add(time.Hour)}, nil
+			})
+		}
+	})
+}
+
+// BenchmarkSOACacheParallelHits looks up cached keys in parallel.
+func BenchmarkSOACacheParallelHits(b *testing.B) {
+	var c soaCache
+	fetch := func() (*soaCacheEntry, error) {
+		return &soaCacheEntry{zone: "example.com.", expires: time.Now().Add(time.Hour)}, nil
+	}
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%d.example.com.", i)
+		_, _ = c.get(keys[i], DefaultCacheSize, fetch)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_, _ = c.get(keys[i%len(keys)], DefaultCacheSize, fetch)
+			i++
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package dns01
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// sendDNSQuery sends m to the nameserver ns, which is either host:port,
+// queried over UDP with a TCP fallback, or a URL:
+//
+//	udp://host[:port]    UDP with a TCP fallback
+//	tcp://host[:port]    TCP
+//	tls://host[:port]    DNS over TLS (RFC 7858), port 853 by default
+//	https://host/path    DNS over HTTPS (RFC 8484)
+func (r *Resolver) sendDNSQuery(m *dns.Msg, ns string) (*dns.Msg, error) {
+	scheme, addr, ok := strings.Cut(ns, "://")
+	if !ok {
+		if r.TCPOnly {
+			return r.exchange("tcp", m, ns)
+		}
+		return r.exchangeUDP(m, ns)
+	}
+	switch scheme {
+	case "udp":
+		return r.exchangeUDP(m, withDefaultPort(addr, "53"))
+	case "tcp":
+		return r.exchange("tcp", m, withDefaultPort(addr, "53"))
+	case "tls":
+		return r.exchange("tcp-tls", m, withDefaultPort(addr, "853"))
+	case "https":
+		return r.exchangeHTTPS(m, ns)
+	default:
+		return nil, fmt.Errorf("unsupported nameserver %s", ns)
+	}
+}
+
+func (r *Resolver) exchange(network string, m *dns.Msg, addr string) (*dns.Msg, error) {
+	c := &dns.Client{Net: network, Timeout: r.timeout()}
+	if network == "tcp-tls" {
+		c.TLSConfig = r.TLSConfig.Clone()
+		if c.TLSConfig == nil {
+			c.TLSConfig = &tls.Config{}
+		}
+		if c.TLSConfig.ServerName == "" {
+			c.TLSConfig.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+	}
+	in, _, err := c.Exchange(m, addr)
+	return in, err
+}
+
+// exchangeHTTPS sends m as a DNS over HTTPS POST request to url.
+func (r *Resolver) exchangeHTTPS(m *dns.Msg, url string) (*dns.Msg, error) {
+	// RFC 8484 recommends an ID of 0 for cacheability.
+	q := m.Copy()
+	q.Id = 0
+	body, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := r.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	in := new(dns.Msg)
+	err = in.Unpack(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response from %s: %w", url, err)
+	}
+	in.Id = m.Id
+	return in, nil
+}
+
+func (r *Resolver) httpClient() *http.Client {
+	r.httpClientOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = r.TLSConfig.Clone()
+		r.httpClientInstance = &http.Client{Transport: transport}
+	})
+	return r.httpClientInstance
+}
+
+// withDefaultPort adds port to addr if it has none.
+func withDefaultPort(addr, port string) string {
+	if _, p, _ := net.SplitHostPort(addr); p == "" {
+		return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+	}
+	return addr
+}
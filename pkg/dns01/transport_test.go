@@ -0,0 +1,123 @@
+package dns01
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// soaHandler answers SOA queries for example.com and its subdomains.
+var soaHandler = dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+	_ = w.WriteMsg(soaReply(req))
+})
+
+func soaReply(req *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	q := req.Question[0]
+	if q.Qtype == dns.TypeSOA && q.Name == "example.com." {
+		m.Answer = append(m.Answer, &dns.SOA{
+			Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+			Ns:     "ns1.example.com.",
+			Mbox:   "hostmaster.example.com.",
+			Minttl: 60,
+		})
+	}
+	return m
+}
+
+// newTLSStandIns starts a DNS over HTTPS and a DNS over TLS server serving
+// soaHandler, and returns their nameserver URLs and a TLS config trusting
+// them.
+func newTLSStandIns(t *testing.T) (string, string, *tls.Config) {
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		req := new(dns.Msg)
+		if err := req.Unpack(b); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		out, _ := soaReply(req).Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(out)
+	}))
+	t.Cleanup(doh.Close)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: doh.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot := &dns.Server{Listener: ln, Handler: soaHandler}
+	go func() { _ = dot.ActivateAndServe() }()
+	t.Cleanup(func() { _ = dot.Shutdown() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(doh.Certificate())
+	return doh.URL + "/dns-query", "tls://" + ln.Addr().String(), &tls.Config{RootCAs: roots}
+}
+
+func TestResolverTLSNameservers(t *testing.T) {
+	dohURL, dotURL, tlsConfig := newTLSStandIns(t)
+
+	for _, ns := range []string{dohURL, dotURL} {
+		t.Run(ns, func(t *testing.T) {
+			r := &Resolver{Nameservers: []string{ns}, TLSConfig: tlsConfig}
+			zone, err := r.FindZoneByFQDN("_acme-challenge.www.example.com.")
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if zone != "example.com." {
+				t.Errorf("expected zone example.com., got %s", zone)
+			}
+		})
+	}
+
+	// The stand-ins aren't trusted without the TLS config.
+	for _, ns := range []string{dohURL, dotURL} {
+		r := &Resolver{}
+		_, err := r.sendDNSQuery(createDNSMsg("example.com.", dns.TypeSOA, true), ns)
+		if err == nil {
+			t.Errorf("%s: expected certificate error", ns)
+		}
+	}
+}
+
+func TestSendDNSQueryUnsupportedScheme(t *testing.T) {
+	r := &Resolver{}
+	_, err := r.sendDNSQuery(createDNSMsg("example.com.", dns.TypeSOA, true), "quic://127.0.0.1")
+	if err == nil || err.Error() != "unsupported nameserver quic://127.0.0.1" {
+		t.Errorf("expected unsupported nameserver error, got %v", err)
+	}
+}
+
+func TestWithDefaultPort(t *testing.T) {
+	testCases := []struct {
+		addr, expected string
+	}{
+		{"1.1.1.1", "1.1.1.1:853"},
+		{"1.1.1.1:8853", "1.1.1.1:8853"},
+		{"dns.example", "dns.example:853"},
+		{"[2606:4700:4700::1111]", "[2606:4700:4700::1111]:853"},
+		{"2606:4700:4700::1111", "[2606:4700:4700::1111]:853"},
+	}
+	for _, test := range testCases {
+		if addr := withDefaultPort(test.addr, "853"); addr != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, addr)
+		}
+	}
+
+	servers := []string{"10.0.0.1", "https://dns.example/dns-query", "tls://dns.example"}
+	populateNameserverPorts(servers)
+	if servers[0] != "10.0.0.1:53" || servers[1] != "https://dns.example/dns-query" || servers[2] != "tls://dns.example" {
+		t.Errorf("unexpected nameservers %v", servers)
+	}
+}
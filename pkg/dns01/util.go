@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"net"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/juju/errors"
@@ -22,48 +21,35 @@ import (
 // up the domain labels until the nameserver returns a SOA record in the
 // answer section.
 func FindZoneByFQDN(fqdn string, nameservers []string) (string, error) {
+	return DefaultResolver.findZoneByFQDN(fqdn, nameservers)
+}
+
+func (r *Resolver) findZoneByFQDN(fqdn string, nameservers []string) (string, error) {
 	if !strings.HasSuffix(fqdn, ".") {
 		fqdn += "."
 	}
-	soa, err := lookupSOAByFQDN(fqdn, nameservers)
+	soa, err := r.lookupSOAByFQDN(fqdn, nameservers)
 	if err != nil {
 		return "", err
 	}
 	return soa.zone, nil
 }
 
-func lookupSOAByFQDN(fqdn string, nameservers []string) (*soaCacheEntry, error) {
+func (r *Resolver) lookupSOAByFQDN(fqdn string, nameservers []string) (*soaCacheEntry, error) {
 	if !strings.HasSuffix(fqdn, ".") {
 		fqdn += "."
 	}
 
-	fqdnSOACacheMu.Lock()
-	defer fqdnSOACacheMu.Unlock()
-
-	// prefer cached version if fresh
-	if ent := fqdnSOACache[fqdn]; ent != nil && !ent.isExpired() {
-		return ent, nil
-	}
-
-	ent, err := fetchSOAByFQDN(fqdn, nameservers)
-	if err != nil {
-		return nil, err
-	}
-
-	// save result to cache, but don't allow
-	// the cache to grow out of control
-	if len(fqdnSOACache) >= 1000 {
-		for key := range fqdnSOACache {
-			delete(fqdnSOACache, key)
-			break
-		}
-	}
-	fqdnSOACache[fqdn] = ent
-
-	return ent, nil
+	// different nameservers may disagree, e.g. in split-horizon setups
+	key := strings.ToLower(fqdn) + "@" + strings.Join(nameservers, ",")
+	return r.cache.get(key, r.cacheSize(), func() (*soaCacheEntry, error) {
+		return r.fetchSOAByFQDN(fqdn, nameservers)
+	})
 }
 
-func fetchSOAByFQDN(fqdn string, nameservers []string) (*soaCacheEntry, error) {
+// fetchSOAByFQDN looks up the SOA record for fqdn. When the lookup ends in
+// NXDOMAIN a negative cache entry is returned along with the error.
+func (r *Resolver) fetchSOAByFQDN(fqdn string, nameservers []string) (*soaCacheEntry, error) {
 	var err error
 	var in *dns.Msg
 
@@ -71,7 +57,11 @@ func fetchSOAByFQDN(fqdn string, nameservers []string) (*soaCacheEntry, error) {
 	for _, index := range labelIndexes {
 		domain := fqdn[index:]
 
-		in, err = dnsQuery(domain, dns.TypeSOA, nameservers, true)
+		in, err = r.dnsQuery(domain, dns.TypeSOA, nameservers, true)
+		if errors.Is(err, errDNSSEC) {
+			// don't fall back to a parent zone
+			return nil, err
+		}
 		if err != nil {
 			continue
 		}
@@ -94,7 +84,7 @@ func fetchSOAByFQDN(fqdn string, nameservers []string) (*soaCacheEntry, error) {
 
 			for _, ans := range in.Answer {
 				if soa, ok := ans.(*dns.SOA); ok {
-					return newSOACacheEntry(soa), nil
+					return r.newSOACacheEntry(soa), nil
 				}
 			}
 		case dns.RcodeNameError:
@@ -105,7 +95,14 @@ func fetchSOAByFQDN(fqdn string, nameservers []string) (*soaCacheEntry, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("could not find the start of authority for %s%s", fqdn, formatDNSError(in, err))
+	err = fmt.Errorf("could not find the start of authority for %s%s", fqdn, formatDNSError(in, err))
+	if in != nil && in.Rcode == dns.RcodeNameError {
+		return &soaCacheEntry{
+			err:     err,
+			expires: time.Now().Add(r.cacheTTL(negativeTTL(in))),
+		}, err
+	}
+	return nil, err
 }
 
 // dnsMsgContainsCNAME checks for a CNAME answer in msg
@@ -118,12 +115,26 @@ func dnsMsgContainsCNAME(msg *dns.Msg) bool {
 	return false
 }
 
-func dnsQuery(fqdn string, rtype uint16, nameservers []string, recursive bool) (*dns.Msg, error) {
+// dnsQuery sends the query to nameservers in turn until one answers. The
+// answers of recursive queries are validated according to r.DNSSEC.
+func (r *Resolver) dnsQuery(fqdn string, rtype uint16, nameservers []string, recursive bool) (*dns.Msg, error) {
+	in, err := r.rawDNSQuery(fqdn, rtype, nameservers, recursive)
+	if err == nil && in != nil && recursive {
+		err = r.validateDNSSEC(in, nameservers)
+	}
+	return in, err
+}
+
+func (r *Resolver) rawDNSQuery(fqdn string, rtype uint16, nameservers []string, recursive bool) (*dns.Msg, error) {
 	m := createDNSMsg(fqdn, rtype, recursive)
+	if r.DNSSEC != "" {
+		m.AuthenticatedData = true
+		m.IsEdns0().SetDo()
+	}
 	var in *dns.Msg
 	var err error
 	for _, ns := range nameservers {
-		in, err = sendDNSQuery(m, ns)
+		in, err = r.sendDNSQuery(m, ns)
 		if err == nil && len(in.Answer) > 0 {
 			break
 		}
@@ -141,15 +152,15 @@ func createDNSMsg(fqdn string, rtype uint16, recursive bool) *dns.Msg {
 	return m
 }
 
-func sendDNSQuery(m *dns.Msg, ns string) (*dns.Msg, error) {
-	udp := &dns.Client{Net: "udp", Timeout: dnsTimeout}
+func (r *Resolver) exchangeUDP(m *dns.Msg, ns string) (*dns.Msg, error) {
+	udp := &dns.Client{Net: "udp", Timeout: r.timeout()}
 	in, _, err := udp.Exchange(m, ns)
 	// two kinds of errors we can handle by retrying with TCP:
 	// truncation and timeout; see https://github.com/caddyserver/caddy/issues/3639
 	truncated := in != nil && in.Truncated
 	timeoutErr := err != nil && strings.Contains(err.Error(), "timeout")
 	if truncated || timeoutErr {
-		tcp := &dns.Client{Net: "tcp", Timeout: dnsTimeout}
+		tcp := &dns.Client{Net: "tcp", Timeout: r.timeout()}
 		in, _, err = tcp.Exchange(m, ns)
 	}
 	return in, err
@@ -169,26 +180,14 @@ func formatDNSError(msg *dns.Msg, err error) string {
 	return ""
 }
 
-// soaCacheEntry holds a cached SOA record (only selected fields)
-type soaCacheEntry struct {
-	zone      string    // zone apex (a domain name)
-	primaryNs string    // primary nameserver for the zone apex
-	expires   time.Time // time when this cache entry should be evicted
-}
-
-func newSOACacheEntry(soa *dns.SOA) *soaCacheEntry {
+func (r *Resolver) newSOACacheEntry(soa *dns.SOA) *soaCacheEntry {
 	return &soaCacheEntry{
 		zone:      soa.Hdr.Name,
 		primaryNs: soa.Ns,
-		expires:   time.Now().Add(time.Duration(soa.Refresh) * time.Second),
+		expires:   time.Now().Add(r.cacheTTL(soaTTL(soa))),
 	}
 }
 
-// isExpired checks whether a cache entry should be considered expired.
-func (cache *soaCacheEntry) isExpired() bool {
-	return time.Now().After(cache.expires)
-}
-
 // systemOrDefaultNameservers attempts to get system nameservers from the
 // resolv.conf file given by path before falling back to hard-coded defaults.
 func systemOrDefaultNameservers(path string, defaults []string) []string {
@@ -200,8 +199,12 @@ func systemOrDefaultNameservers(path string, defaults []string) []string {
 }
 
 // populateNameserverPorts ensures that all nameservers have a port number.
+// Nameserver URLs are left as they are.
 func populateNameserverPorts(servers []string) {
 	for i := range servers {
+		if strings.Contains(servers[i], "://") {
+			continue
+		}
 		_, port, _ := net.SplitHostPort(servers[i])
 		if port == "" {
 			servers[i] = net.JoinHostPort(servers[i], "53")
@@ -210,20 +213,20 @@ func populateNameserverPorts(servers []string) {
 }
 
 // lookupNameservers returns the authoritative nameservers for the given fqdn.
-func lookupNameservers(fqdn string, resolvers []string) ([]string, error) {
+func (r *Resolver) lookupNameservers(fqdn string, resolvers []string) ([]string, error) {
 	var authoritativeNss []string
 
-	zone, err := FindZoneByFQDN(fqdn, resolvers)
+	zone, err := r.findZoneByFQDN(fqdn, resolvers)
 	if err != nil {
 		return nil, fmt.Errorf("could not determine the zone: %w", err)
 	}
 
-	r, err := dnsQuery(zone, dns.TypeNS, resolvers, true)
+	in, err := r.dnsQuery(zone, dns.TypeNS, resolvers, true)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, rr := range r.Answer {
+	for _, rr := range in.Answer {
 		if ns, ok := rr.(*dns.NS); ok {
 			authoritativeNss = append(authoritativeNss, strings.ToLower(ns.Ns))
 		}
@@ -252,11 +255,4 @@ var defaultNameservers = []string{
 	"1.0.0.1:53",
 }
 
-var dnsTimeout = 10 * time.Second
-
-var (
-	fqdnSOACache   = map[string]*soaCacheEntry{}
-	fqdnSOACacheMu sync.Mutex
-)
-
 const defaultResolvConf = "/etc/resolv.conf"
@@ -8,37 +8,80 @@ package dns01
 // It has been modified.
 
 import (
+	"os"
 	"reflect"
 	"sort"
 	"strings"
 	"testing"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/dnstest"
 )
 
+// testZones stands in for the public DNS in the tests of this package.
+const testZones = `
+$ORIGIN example.com.
+@                     IN SOA   ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300
+@                     IN NS    ns1.example.com.
+@                     IN NS    ns2.example.com.
+www                   IN A     192.0.2.10
+scholar               IN CNAME scholar.l.example.com.
+scholar.l             IN A     192.0.2.11
+cross-zone            IN CNAME www.example.net.
+_acme-challenge.www   IN TXT   "token"
+_acme-challenge.alias IN CNAME _acme-challenge.validation.example.net.
+loop-a                IN CNAME loop-b.example.com.
+loop-b                IN CNAME loop-a.example.com.
+
+$ORIGIN example.net.
+@                          IN SOA ns1.example.net. hostmaster.example.net. 1 7200 3600 1209600 300
+@                          IN NS  ns1.example.net.
+www                        IN A   192.0.2.20
+_acme-challenge.validation IN TXT "delegated"
+
+$ORIGIN physics.example.net.
+@                          IN SOA ns3.example.net. hostmaster.example.net. 1 7200 3600 1209600 300
+@                          IN NS  ns3.example.net.
+@                          IN NS  ns4.example.net.
+
+$ORIGIN ac.
+@ IN SOA a0.nic.ac. hostmaster.nic.ac. 1 7200 3600 1209600 300
+`
+
+// testServer serves testZones.
+var testServer *dnstest.Server
+
+func TestMain(m *testing.M) {
+	testServer = dnstest.NewZoneServer(testZones)
+	code := m.Run()
+	testServer.Close()
+	os.Exit(code)
+}
+
 func TestLookupNameserversOK(t *testing.T) {
 	testCases := []struct {
 		fqdn string
 		nss  []string
 	}{
 		{
-			fqdn: "books.google.com.ng.",
-			nss:  []string{"ns1.google.com.", "ns2.google.com.", "ns3.google.com.", "ns4.google.com."},
+			fqdn: "books.example.com.",
+			nss:  []string{"ns1.example.com.", "ns2.example.com."},
 		},
 		{
-			fqdn: "www.google.com.",
-			nss:  []string{"ns1.google.com.", "ns2.google.com.", "ns3.google.com.", "ns4.google.com."},
+			fqdn: "www.example.com.",
+			nss:  []string{"ns1.example.com.", "ns2.example.com."},
 		},
 		{
-			fqdn: "physics.georgetown.edu.",
-			nss:  []string{"ns4.georgetown.edu.", "ns5.georgetown.edu.", "ns6.georgetown.edu."},
+			fqdn: "lab.physics.example.net.",
+			nss:  []string{"ns3.example.net.", "ns4.example.net."},
 		},
 	}
 
 	for i, test := range testCases {
-		test := test
+		i, test := i, test
 		t.Run(test.fqdn, func(t *testing.T) {
 			t.Parallel()
 
-			nss, err := lookupNameservers(test.fqdn, RecursiveNameservers(nil))
+			nss, err := DefaultResolver.lookupNameservers(test.fqdn, []string{testServer.Addr})
 			if err != nil {
 				t.Errorf("Expected no error, got: %v", err)
 			}
@@ -67,13 +110,13 @@ func TestLookupNameserversErr(t *testing.T) {
 	}
 
 	for i, test := range testCases {
-		test := test
+		i, test := i, test
 		t.Run(test.desc, func(t *testing.T) {
 			t.Parallel()
 
-			_, err := lookupNameservers(test.fqdn, nil)
+			_, err := DefaultResolver.lookupNameservers(test.fqdn, []string{testServer.Addr})
 			if err == nil {
-				t.Errorf("expected error, got none")
+				t.Fatalf("expected error, got none")
 			}
 			if !strings.Contains(err.Error(), test.error) {
 				t.Errorf("Test %d: Expected error to contain '%s' but got '%s'", i, test.error, err.Error())
@@ -82,74 +125,90 @@ func TestLookupNameserversErr(t *testing.T) {
 	}
 }
 
-var findXByFQDNTestCases = []struct {
+func findXByFQDNTestCases() []struct {
 	desc          string
 	fqdn          string
 	zone          string
 	primaryNs     string
 	nameservers   []string
 	expectedError string
-}{
-	{
-		desc:        "domain is a CNAME",
-		fqdn:        "scholar.google.com.",
-		zone:        "google.com.",
-		primaryNs:   "ns1.google.com.",
-		nameservers: RecursiveNameservers(nil),
-	},
-	{
-		desc:        "domain is a non-existent subdomain",
-		fqdn:        "foo.google.com.",
-		zone:        "google.com.",
-		primaryNs:   "ns1.google.com.",
-		nameservers: RecursiveNameservers(nil),
-	},
-	{
-		desc:        "domain is a eTLD",
-		fqdn:        "example.com.ac.",
-		zone:        "ac.",
-		primaryNs:   "a0.nic.ac.",
-		nameservers: RecursiveNameservers(nil),
-	},
-	{
-		desc:        "domain is a cross-zone CNAME",
-		fqdn:        "cross-zone-example.assets.sh.",
-		zone:        "assets.sh.",
-		primaryNs:   "gina.ns.cloudflare.com.",
-		nameservers: RecursiveNameservers(nil),
-	},
-	{
-		desc:          "NXDOMAIN",
-		fqdn:          "test.loho.jkl.",
-		zone:          "loho.jkl.",
-		nameservers:   []string{"1.1.1.1:53"},
-		expectedError: "could not find the start of authority for test.loho.jkl.: NXDOMAIN",
-	},
-	{
-		desc:        "several non existent nameservers",
-		fqdn:        "scholar.google.com.",
-		zone:        "google.com.",
-		primaryNs:   "ns1.google.com.",
-		nameservers: []string{":7053", ":8053", "1.1.1.1:53"},
-	},
-	{
-		desc:          "only non existent nameservers",
-		fqdn:          "scholar.google.com.",
-		zone:          "google.com.",
-		nameservers:   []string{":7053", ":8053", ":9053"},
-		expectedError: "could not find the start of authority for scholar.google.com.:",
-	},
-	{
-		desc:          "no nameservers",
-		fqdn:          "test.ldez.com.",
-		zone:          "ldez.com.",
-		nameservers:   []string{},
-		expectedError: "could not find the start of authority for test.ldez.com.",
-	},
+} {
+	return []struct {
+		desc          string
+		fqdn          string
+		zone          string
+		primaryNs     string
+		nameservers   []string
+		expectedError string
+	}{
+		{
+			desc:        "domain is a CNAME",
+			fqdn:        "scholar.example.com.",
+			zone:        "example.com.",
+			primaryNs:   "ns1.example.com.",
+			nameservers: []string{testServer.Addr},
+		},
+		{
+			desc:        "domain is a non-existent subdomain",
+			fqdn:        "foo.example.com.",
+			zone:        "example.com.",
+			primaryNs:   "ns1.example.com.",
+			nameservers: []string{testServer.Addr},
+		},
+		{
+			desc:        "domain is a eTLD",
+			fqdn:        "example.com.ac.",
+			zone:        "ac.",
+			primaryNs:   "a0.nic.ac.",
+			nameservers: []string{testServer.Addr},
+		},
+		{
+			desc:        "domain is a cross-zone CNAME",
+			fqdn:        "cross-zone.example.com.",
+			zone:        "example.com.",
+			primaryNs:   "ns1.example.com.",
+			nameservers: []string{testServer.Addr},
+		},
+		{
+			desc:        "domain is in a sub zone",
+			fqdn:        "_acme-challenge.lab.physics.example.net.",
+			zone:        "physics.example.net.",
+			primaryNs:   "ns3.example.net.",
+			nameservers: []string{testServer.Addr},
+		},
+		{
+			desc:          "NXDOMAIN",
+			fqdn:          "test.loho.jkl.",
+			zone:          "loho.jkl.",
+			nameservers:   []string{testServer.Addr},
+			expectedError: "could not find the start of authority for test.loho.jkl.: NXDOMAIN",
+		},
+		{
+			desc:        "several non existent nameservers",
+			fqdn:        "scholar.example.com.",
+			zone:        "example.com.",
+			primaryNs:   "ns1.example.com.",
+			nameservers: []string{"127.0.0.1:7053", "127.0.0.1:8053", testServer.Addr},
+		},
+		{
+			desc:          "only non existent nameservers",
+			fqdn:          "scholar.example.com.",
+			zone:          "example.com.",
+			nameservers:   []string{"127.0.0.1:7053", "127.0.0.1:8053", "127.0.0.1:9053"},
+			expectedError: "could not find the start of authority for scholar.example.com.:",
+		},
+		{
+			desc:          "no nameservers",
+			fqdn:          "test.example.com.",
+			zone:          "example.com.",
+			nameservers:   []string{},
+			expectedError: "could not find the start of authority for test.example.com.",
+		},
+	}
 }
 
 func TestFindZoneByFQDN(t *testing.T) {
-	for i, test := range findXByFQDNTestCases {
+	for i, test := range findXByFQDNTestCases() {
 		t.Run(test.desc, func(t *testing.T) {
 			clearFQDNCache()
 
@@ -174,6 +233,25 @@ func TestFindZoneByFQDN(t *testing.T) {
 	}
 }
 
+func TestLookupSOAByFQDN(t *testing.T) {
+	for i, test := range findXByFQDNTestCases() {
+		if test.expectedError != "" {
+			continue
+		}
+		t.Run(test.desc, func(t *testing.T) {
+			clearFQDNCache()
+
+			soa, err := DefaultResolver.lookupSOAByFQDN(test.fqdn, test.nameservers)
+			if err != nil {
+				t.Fatalf("test %d: expected no error, but got: %v", i, err)
+			}
+			if soa.primaryNs != test.primaryNs {
+				t.Errorf("test %d: expected primary nameserver '%s' but got '%s'", i, test.primaryNs, soa.primaryNs)
+			}
+		})
+	}
+}
+
 func TestResolveConfServers(t *testing.T) {
 	var testCases = []struct {
 		fixture  string
@@ -223,7 +301,5 @@ func TestRecursiveNameserversAddsPort(t *testing.T) {
 }
 
 func clearFQDNCache() {
-	fqdnSOACacheMu.Lock()
-	fqdnSOACache = make(map[string]*soaCacheEntry)
-	fqdnSOACacheMu.Unlock()
+	DefaultResolver.cache.clear()
 }
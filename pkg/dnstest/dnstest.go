@@ -0,0 +1,183 @@
+// Package dnstest provides in-process DNS servers for tests, in the spirit
+// of net/http/httptest.
+package dnstest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain is the number of CNAMEs Zones follows in one answer.
+const maxCNAMEChain = 8
+
+// Zones answers queries from zone file data. It is authoritative for every
+// zone with an SOA record in the data, and follows CNAMEs within the data
+// like a recursive resolver would, so it can stand in for both.
+type Zones struct {
+	records map[string][]dns.RR
+	apexes  []string
+}
+
+// ParseZones parses zone file data. The origin is the root zone until the
+// first $ORIGIN directive, and $TTL defaults to 300.
+func ParseZones(data string) (*Zones, error) {
+	z := &Zones{records: map[string][]dns.RR{}}
+	zp := dns.NewZoneParser(strings.NewReader("$TTL 300\n"+data), ".", "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		name := strings.ToLower(rr.Header().Name)
+		z.records[name] = append(z.records[name], rr)
+		if rr.Header().Rrtype == dns.TypeSOA {
+			z.apexes = append(z.apexes, name)
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// MustParseZones is like ParseZones but panics on errors.
+func MustParseZones(data string) *Zones {
+	z, err := ParseZones(data)
+	if err != nil {
+		panic(fmt.Sprintf("dnstest: %v", err))
+	}
+	return z
+}
+
+// ServeDNS implements dns.Handler. Names outside of every zone are
+// answered with NXDOMAIN.
+func (z *Zones) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	if len(req.Question) != 1 {
+		m.Rcode = dns.RcodeFormatError
+		_ = w.WriteMsg(m)
+		return
+	}
+	q := req.Question[0]
+	name := strings.ToLower(q.Name)
+
+	for i := 0; ; i++ {
+		apex := z.zoneOf(name)
+		if apex == "" {
+			if len(m.Answer) == 0 {
+				m.Rcode = dns.RcodeNameError
+			}
+			break
+		}
+		m.Authoritative = true
+
+		rrs := z.records[name]
+		cname := findType(rrs, dns.TypeCNAME)
+		if len(cname) > 0 && q.Qtype != dns.TypeCNAME && i < maxCNAMEChain {
+			m.Answer = append(m.Answer, cname...)
+			name = strings.ToLower(cname[0].(*dns.CNAME).Target)
+			continue
+		}
+
+		answers := findType(rrs, q.Qtype)
+		m.Answer = append(m.Answer, answers...)
+		if len(answers) == 0 {
+			if !z.exists(name) {
+				m.Rcode = dns.RcodeNameError
+			}
+			m.Ns = append(m.Ns, findType(z.records[apex], dns.TypeSOA)...)
+		}
+		break
+	}
+	_ = w.WriteMsg(m)
+}
+
+// zoneOf returns the closest zone apex of name, or "" if name is in no
+// zone.
+func (z *Zones) zoneOf(name string) string {
+	match := ""
+	for _, apex := range z.apexes {
+		if dns.IsSubDomain(apex, name) && len(apex) > len(match) {
+			match = apex
+		}
+	}
+	return match
+}
+
+// exists reports whether name has records or is an empty non-terminal.
+func (z *Zones) exists(name string) bool {
+	for owner := range z.records {
+		if dns.IsSubDomain(name, owner) {
+			return true
+		}
+	}
+	return false
+}
+
+func findType(rrs []dns.RR, rrtype uint16) []dns.RR {
+	var found []dns.RR
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == rrtype {
+			found = append(found, dns.Copy(rr))
+		}
+	}
+	return found
+}
+
+// Server is a DNS server listening on UDP and TCP on the same loopback
+// address.
+type Server struct {
+	// Addr is the host:port the server listens on.
+	Addr string
+
+	udp *dns.Server
+	tcp *dns.Server
+}
+
+// NewServer starts a Server serving handler. It panics if it cannot
+// listen.
+func NewServer(handler dns.Handler) *Server {
+	var pc net.PacketConn
+	var l net.Listener
+	var err error
+	for i := 0; i < 10; i++ {
+		pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			break
+		}
+		// Use the same port for TCP, retrying if it's taken.
+		l, err = net.Listen("tcp", pc.LocalAddr().String())
+		if err == nil {
+			break
+		}
+		pc.Close()
+	}
+	if err != nil {
+		panic(fmt.Sprintf("dnstest: failed to listen: %v", err))
+	}
+
+	var started sync.WaitGroup
+	started.Add(2)
+	s := &Server{
+		Addr: pc.LocalAddr().String(),
+		udp:  &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: started.Done},
+		tcp:  &dns.Server{Listener: l, Handler: handler, NotifyStartedFunc: started.Done},
+	}
+	go func() { _ = s.udp.ActivateAndServe() }()
+	go func() { _ = s.tcp.ActivateAndServe() }()
+	started.Wait()
+	return s
+}
+
+// NewZoneServer starts a Server answering from zone file data, see
+// ParseZones. It panics if the data is invalid.
+func NewZoneServer(data string) *Server {
+	return NewServer(MustParseZones(data))
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	_ = s.udp.Shutdown()
+	_ = s.tcp.Shutdown()
+}
@@ -0,0 +1,49 @@
+package dnstest
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestZoneServer(t *testing.T) {
+	s := NewZoneServer(`
+$ORIGIN example.com.
+@                   IN SOA   ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300
+www                 IN A     192.0.2.1
+alias               IN CNAME www.example.com.
+_acme-challenge.sub IN TXT   "token"
+`)
+	defer s.Close()
+
+	testCases := []struct {
+		name    string
+		qtype   uint16
+		rcode   int
+		answers int
+		soa     bool
+	}{
+		{name: "www.example.com.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, answers: 1},
+		{name: "WWW.example.com.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, answers: 1},
+		{name: "www.example.com.", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess, soa: true},
+		{name: "alias.example.com.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, answers: 2},
+		{name: "alias.example.com.", qtype: dns.TypeCNAME, rcode: dns.RcodeSuccess, answers: 1},
+		{name: "sub.example.com.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, soa: true},
+		{name: "missing.example.com.", qtype: dns.TypeA, rcode: dns.RcodeNameError, soa: true},
+		{name: "example.org.", qtype: dns.TypeSOA, rcode: dns.RcodeNameError},
+	}
+	for _, network := range []string{"udp", "tcp"} {
+		c := &dns.Client{Net: network}
+		for _, test := range testCases {
+			m := new(dns.Msg)
+			m.SetQuestion(test.name, test.qtype)
+			in, _, err := c.Exchange(m, s.Addr)
+			if err != nil {
+				t.Fatalf("%s %s: %v", network, test.name, err)
+			}
+			if in.Rcode != test.rcode || len(in.Answer) != test.answers || (len(in.Ns) > 0) != test.soa {
+				t.Errorf("%s %s %s: unexpected response %v", network, test.name, dns.TypeToString[test.qtype], in)
+			}
+		}
+	}
+}
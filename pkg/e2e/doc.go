@@ -0,0 +1,4 @@
+// Package e2e contains end-to-end tests that run acmep's listeners, proxy
+// and provider together over real HTTP, with records kept in a
+// dns.MemoryProvider.
+package e2e
@@ -0,0 +1,264 @@
+package e2e_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/client"
+	"github.com/hpidcock/acme-dns-proxy/pkg/config"
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns"
+	"github.com/hpidcock/acme-dns-proxy/pkg/listener"
+	"github.com/hpidcock/acme-dns-proxy/pkg/proxy"
+)
+
+// acmep is an acmep server started by startAcmep.
+type acmep struct {
+	// URL is the base URL of the listener.
+	URL string
+	// Records holds the records written by the provider.
+	Records *dns.MemoryProvider
+	// Provider and Store track the pending challenges.
+	Provider dns.Provider
+	Store    dns.Store
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// freeAddress returns a loopback address with a port that was free a moment
+// ago, as listener.Serve listens on the configured address itself.
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// generateConfig returns a config with a single listener on addr, a
+// provider managing domain.example. and ACLs for service-0 (basic auth) and
+// *.sub.domain.example (bearer auth).
+func generateConfig(addr string) string {
+	return fmt.Sprintf(`
+listener "test" {
+	address = %q
+	auth    = ["basic", "bearer"]
+}
+provider "memory" {
+	zones = ["domain.example"]
+}
+acl "service-0.domain.example" {
+	token = %q
+}
+acl "*.sub.domain.example" {
+	token        = %q
+	max_lifetime = "10m"
+}
+`[1:], addr, tokenHash("service-0:secret"), tokenHash("sub-token"))
+}
+
+// startAcmep serves the config returned by generateConfig with
+// listener.Serve on a random port until the test ends.
+func startAcmep(t *testing.T) *acmep {
+	addr := freeAddress(t)
+	cfg, err := config.Parse(generateConfig(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := logrus.New()
+	log.SetLevel(logrus.WarnLevel)
+	store := dns.NewMemoryStore()
+	provider, err := dns.NewProviderFromConfig(log, &cfg.Provider, nil, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acls, err := proxy.NewACLsFromConfig(cfg.ACLs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.NewServer(cfg.Listeners[0], proxy.Proxy{
+		Log:      log,
+		Provider: provider,
+		ACLs:     acls,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan any)
+	go listener.Serve(ctx, log, server, done)
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	a := &acmep{
+		URL:      "http://" + addr,
+		Records:  provider.Underlying().(*dns.MemoryProvider),
+		Provider: provider,
+		Store:    store,
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(a.URL + "/")
+		if err == nil {
+			resp.Body.Close()
+			return a
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("listener did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (a *acmep) records(t *testing.T) []libdns.Record {
+	records, err := a.Records.GetRecords(context.Background(), "domain.example.")
+	assert.NoError(t, err)
+	return records
+}
+
+func TestPresentCleanup(t *testing.T) {
+	a := startAcmep(t)
+	ctx := context.Background()
+	c := &client.Client{Server: a.URL, Username: "service-0", Password: "secret", Retries: -1}
+
+	assert.NoError(t, c.Present(ctx, "service-0.domain.example", "value-0"))
+	records := a.records(t)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "TXT", records[0].Type)
+		assert.Equal(t, "_acme-challenge.service-0", records[0].Name)
+		assert.Equal(t, "value-0", records[0].Value)
+	}
+
+	assert.NoError(t, c.Cleanup(ctx, "_acme-challenge.service-0.domain.example.", "value-0"))
+	assert.Empty(t, a.records(t))
+}
+
+func TestPresentCleanupBearer(t *testing.T) {
+	a := startAcmep(t)
+	ctx := context.Background()
+	c := &client.Client{Server: a.URL, Token: "sub-token", Retries: -1}
+
+	assert.NoError(t, c.Present(ctx, "a.sub.domain.example", "value-a"))
+	assert.NoError(t, c.Present(ctx, "b.sub.domain.example", "value-b"))
+	assert.Len(t, a.records(t), 2)
+
+	assert.NoError(t, c.Cleanup(ctx, "a.sub.domain.example", "value-a"))
+	records := a.records(t)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "_acme-challenge.b.sub", records[0].Name)
+	}
+}
+
+func TestLegoRawRequest(t *testing.T) {
+	a := startAcmep(t)
+
+	post := func(action string) int {
+		body := `{"domain": "service-0.domain.example", "token": "token", "keyAuth": "token.thumbprint"}`
+		req, err := http.NewRequest("POST", a.URL+"/"+action, strings.NewReader(body))
+		assert.NoError(t, err)
+		req.SetBasicAuth("service-0", "secret")
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, post("present"))
+	records := a.records(t)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "_acme-challenge.service-0", records[0].Name)
+		assert.NotEqual(t, "token.thumbprint", records[0].Value)
+	}
+	assert.Equal(t, http.StatusOK, post("cleanup"))
+	assert.Empty(t, a.records(t))
+}
+
+func TestACLDenied(t *testing.T) {
+	a := startAcmep(t)
+	ctx := context.Background()
+
+	testCases := []struct {
+		name   string
+		client *client.Client
+		fqdn   string
+	}{{
+		name:   "other domain",
+		client: &client.Client{Username: "service-0", Password: "secret"},
+		fqdn:   "service-1.domain.example",
+	}, {
+		name:   "wrong password",
+		client: &client.Client{Username: "service-0", Password: "wrong"},
+		fqdn:   "service-0.domain.example",
+	}, {
+		name:   "token of another acl",
+		client: &client.Client{Token: "sub-token"},
+		fqdn:   "service-0.domain.example",
+	}, {
+		name:   "wildcard parent",
+		client: &client.Client{Token: "sub-token"},
+		fqdn:   "sub.domain.example",
+	}}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			c := test.client
+			c.Server = a.URL
+			c.Retries = -1
+			err := c.Present(ctx, test.fqdn, "value")
+			assert.ErrorContains(t, err, "401 Unauthorized")
+			assert.Empty(t, a.records(t))
+		})
+	}
+
+	// A denied cleanup must not delete records presented by others.
+	owner := &client.Client{Server: a.URL, Username: "service-0", Password: "secret", Retries: -1}
+	assert.NoError(t, owner.Present(ctx, "service-0.domain.example", "value"))
+	other := &client.Client{Server: a.URL, Token: "sub-token", Retries: -1}
+	assert.ErrorContains(t, other.Cleanup(ctx, "service-0.domain.example", "value"), "401 Unauthorized")
+	assert.Len(t, a.records(t), 1)
+}
+
+func TestUpstreamExpiry(t *testing.T) {
+	a := startAcmep(t)
+	ctx := context.Background()
+	store := dns.NewMemoryStore()
+	downstream := dns.NewUpstreamProvider(&client.Client{Server: a.URL, Token: "sub-token", Retries: -1}, store)
+	assert.NoError(t, downstream.Present(ctx, dns.Challenge{FQDN: "a.sub.domain.example.", EncodedKeyAuth: "value", Lifetime: 10 * time.Millisecond}))
+	assert.Len(t, a.records(t), 1)
+	time.Sleep(20 * time.Millisecond)
+
+	// The upstream was given the lifetime and expires the record first.
+	log, hook := logtest.NewNullLogger()
+	upstreamExpirer := &dns.Expirer{Log: log, Provider: a.Provider, Store: a.Store}
+	upstreamExpirer.Expire(ctx, time.Now())
+	assert.Empty(t, a.records(t))
+
+	downstreamExpirer := &dns.Expirer{Log: log, Provider: downstream, Store: store}
+	downstreamExpirer.Expire(ctx, time.Now())
+	pending, err := downstream.Pending()
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+	for _, entry := range hook.AllEntries() {
+		assert.NotEqual(t, logrus.ErrorLevel, entry.Level, entry.Message)
+	}
+}
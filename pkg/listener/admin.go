@@ -0,0 +1,206 @@
+package listener
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns"
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns01"
+	"github.com/hpidcock/acme-dns-proxy/pkg/proxy"
+)
+
+const (
+	// ProtocolAdmin serves the admin API.
+	ProtocolAdmin = "admin"
+)
+
+// newAdminHandler serves the admin API:
+//
+//	GET    /challenges[?fqdn=name]  list pending challenges
+//	DELETE /challenges/{id}         force cleanup of one pending challenge
+//	DELETE /challenges?fqdn=name    force cleanup of all pending challenges for name
+//	GET    /records[?zone=name]     list the records of the provider
+//	POST   /reload                  reload the config
+func newAdminHandler(p proxy.Proxy, auth []string, tokens []string, reload func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := authToken(r, auth)
+		if err != nil || !checkAdminToken(tokens, token) {
+			p.Log.Errorf("unauthorized: %s %s", r.Method, r.URL.String())
+			unauthorized(w)
+			return
+		}
+
+		path := strings.Trim(r.URL.Path, "/")
+		switch {
+		case path == "challenges" && r.Method == "GET":
+			listChallenges(w, r, p)
+		case path == "challenges" && r.Method == "DELETE":
+			fqdn := r.URL.Query().Get("fqdn")
+			if fqdn == "" {
+				badRequest(w, fmt.Errorf("fqdn not set"))
+				return
+			}
+			pending, err := p.Provider.Pending()
+			if err != nil {
+				p.Log.Errorf("admin: %s", err.Error())
+				internalServerError(w, err)
+				return
+			}
+			var ids []string
+			for _, pc := range pending {
+				if pc.FQDN == dns01.ToFQDN(fqdn) {
+					ids = append(ids, pc.ID)
+				}
+			}
+			forceCleanup(w, r, p, ids)
+		case strings.HasPrefix(path, "challenges/") && r.Method == "DELETE":
+			forceCleanup(w, r, p, []string{strings.TrimPrefix(path, "challenges/")})
+		case path == "records" && r.Method == "GET":
+			listRecords(w, r, p)
+		case path == "reload" && r.Method == "POST":
+			p.Log.Info("admin: reload requested")
+			reload()
+			ok(w)
+		case path == "challenges" || path == "records" || path == "reload" || strings.HasPrefix(path, "challenges/"):
+			methodNotAllowed(w)
+		default:
+			notFound(w)
+		}
+	}
+}
+
+func checkAdminToken(tokens []string, token string) bool {
+	valid := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+type pendingChallengeResponse struct {
+	dns.PendingChallenge
+	Age string `json:"age"`
+}
+
+func listChallenges(w http.ResponseWriter, r *http.Request, p proxy.Proxy) {
+	fqdn := r.URL.Query().Get("fqdn")
+	pending, err := p.Provider.Pending()
+	if err != nil {
+		p.Log.Errorf("admin: %s", err.Error())
+		internalServerError(w, err)
+		return
+	}
+	res := []pendingChallengeResponse{}
+	for _, pc := range pending {
+		if fqdn != "" && pc.FQDN != dns01.ToFQDN(fqdn) {
+			continue
+		}
+		res = append(res, pendingChallengeResponse{
+			PendingChallenge: pc,
+			Age:              time.Since(pc.Created).Round(time.Second).String(),
+		})
+	}
+	writeJSON(w, p, res)
+}
+
+type recordResponse struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	TTL   string `json:"ttl"`
+}
+
+type zoneRecordsResponse struct {
+	Zone    string           `json:"zone"`
+	Records []recordResponse `json:"records"`
+}
+
+// listRecords lists the records of the zone given by the zone parameter, or
+// of every zone if the provider can list its zones.
+func listRecords(w http.ResponseWriter, r *http.Request, p proxy.Proxy) {
+	underlying := p.Provider.Underlying()
+	var zones []string
+	if zone := r.URL.Query().Get("zone"); zone != "" {
+		zones = []string{dns01.ToFQDN(zone)}
+	} else if lister, ok := underlying.(dns.ZoneLister); ok {
+		listed, err := lister.ListZones(r.Context())
+		if err != nil {
+			p.Log.Errorf("admin: list zones: %s", err.Error())
+			internalServerError(w, err)
+			return
+		}
+		for _, z := range listed {
+			zones = append(zones, z.Name)
+		}
+	} else {
+		badRequest(w, fmt.Errorf("zone not set"))
+		return
+	}
+
+	res := []zoneRecordsResponse{}
+	for _, zone := range zones {
+		records, err := underlying.GetRecords(r.Context(), zone)
+		if errors.Is(err, errors.NotSupported) {
+			p.Log.Errorf("admin: %s", err.Error())
+			badRequest(w, err)
+			return
+		} else if err != nil {
+			p.Log.Errorf("admin: get records of %s: %s", zone, err.Error())
+			internalServerError(w, err)
+			return
+		}
+		zr := zoneRecordsResponse{Zone: zone, Records: []recordResponse{}}
+		for _, rec := range records {
+			zr.Records = append(zr.Records, recordResponse{
+				ID:    rec.ID,
+				Type:  rec.Type,
+				Name:  rec.Name,
+				Value: rec.Value,
+				TTL:   rec.TTL.String(),
+			})
+		}
+		res = append(res, zr)
+	}
+	writeJSON(w, p, res)
+}
+
+func forceCleanup(w http.ResponseWriter, r *http.Request, p proxy.Proxy, ids []string) {
+	cleaned := []string{}
+	for _, id := range ids {
+		err := p.Provider.ForceCleanup(r.Context(), id)
+		if errors.Is(err, errors.NotFound) {
+			p.Log.Errorf("admin: %s", err.Error())
+			notFound(w)
+			return
+		} else if err != nil {
+			p.Log.Errorf("admin: force cleanup %s: %s", id, err.Error())
+			internalServerError(w, err)
+			return
+		}
+		p.Log.Infof("admin: force cleaned up pending challenge %s", id)
+		cleaned = append(cleaned, id)
+	}
+	writeJSON(w, p, struct {
+		Cleaned []string `json:"cleaned"`
+	}{cleaned})
+}
+
+func writeJSON(w http.ResponseWriter, p proxy.Proxy, v any) {
+	response, err := json.Marshal(v)
+	if err != nil {
+		p.Log.Errorf("internal server error: %s", err.Error())
+		internalServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
@@ -0,0 +1,136 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/libdns/libdns"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/config"
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns"
+	"github.com/hpidcock/acme-dns-proxy/pkg/proxy"
+)
+
+type fakeProvider struct {
+	dns.Provider
+	pending    []dns.PendingChallenge
+	pendingErr error
+	cleaned    []string
+}
+
+func (f *fakeProvider) Pending() ([]dns.PendingChallenge, error) {
+	return f.pending, f.pendingErr
+}
+
+func (f *fakeProvider) ForceCleanup(ctx context.Context, id string) error {
+	for i, pc := range f.pending {
+		if pc.ID == id {
+			f.pending = append(f.pending[:i], f.pending[i+1:]...)
+			f.cleaned = append(f.cleaned, id)
+			return nil
+		}
+	}
+	return errors.NotFoundf("pending challenge %q", id)
+}
+
+func (f *fakeProvider) Underlying() interface {
+	libdns.RecordGetter
+	libdns.RecordAppender
+	libdns.RecordSetter
+	libdns.RecordDeleter
+} {
+	return nil
+}
+
+func TestAdminHandler(t *testing.T) {
+	provider := &fakeProvider{pending: []dns.PendingChallenge{
+		{ID: "1", Principal: "*.domain.example", FQDN: "a.domain.example.", Zone: "domain.example.", RecordID: "r1", Created: time.Now()},
+		{ID: "2", Principal: "*.domain.example", FQDN: "b.domain.example.", Zone: "domain.example.", RecordID: "r2", Created: time.Now()},
+		{ID: "3", Principal: "*.domain.example", FQDN: "b.domain.example.", Zone: "domain.example.", RecordID: "r3", Created: time.Now()},
+	}}
+	reloaded := false
+	server, err := NewServer(config.Listener{
+		Name:        "admin",
+		Protocol:    ProtocolAdmin,
+		Auth:        []string{AuthBearer},
+		AdminTokens: []string{hashToken("admin token")},
+	}, proxy.Proxy{
+		Log:      logrus.New(),
+		Provider: provider,
+	}, func() { reloaded = true })
+	assert.NoError(t, err)
+
+	do := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/challenges", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/challenges", "wrong").Code)
+
+	rec := do("GET", "/challenges?fqdn=b.domain.example", "admin token")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var list []map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Len(t, list, 2)
+	assert.Equal(t, "2", list[0]["id"])
+	assert.Equal(t, "r2", list[0]["record_id"])
+	assert.Equal(t, "0s", list[0]["age"])
+
+	assert.Equal(t, http.StatusOK, do("DELETE", "/challenges/1", "admin token").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/challenges/1", "admin token").Code)
+	assert.Equal(t, http.StatusBadRequest, do("DELETE", "/challenges", "admin token").Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/challenges?fqdn=b.domain.example.", "admin token").Code)
+	assert.Equal(t, []string{"1", "2", "3"}, provider.cleaned)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, do("GET", "/reload", "admin token").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/reload", "admin token").Code)
+	assert.True(t, reloaded)
+
+	provider.pendingErr = errors.New("state file corrupt")
+	assert.Equal(t, http.StatusInternalServerError, do("GET", "/challenges", "admin token").Code)
+	assert.Equal(t, http.StatusInternalServerError, do("DELETE", "/challenges?fqdn=b.domain.example.", "admin token").Code)
+
+	_, err = NewServer(config.Listener{Name: "admin", Protocol: ProtocolAdmin}, proxy.Proxy{}, nil)
+	assert.ErrorContains(t, err, "admin_tokens not set")
+}
+
+func TestAdminRecords(t *testing.T) {
+	underlying := &dns.MemoryProvider{}
+	provider, err := dns.NewProvider(underlying, dns.NewStaticZoneResolver([]string{"domain.example"}, nil), nil)
+	assert.NoError(t, err)
+	server, err := NewServer(config.Listener{
+		Name:        "admin",
+		Protocol:    ProtocolAdmin,
+		Auth:        []string{AuthBearer},
+		AdminTokens: []string{hashToken("admin token")},
+	}, proxy.Proxy{
+		Log:      logrus.New(),
+		Provider: provider,
+	}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, provider.Present(context.Background(), dns.Challenge{FQDN: "a.domain.example.", EncodedKeyAuth: "value"}))
+
+	for _, target := range []string{"/records", "/records?zone=domain.example"} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer admin token")
+		rec := httptest.NewRecorder()
+		server.Handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `[{"zone": "domain.example.", "records": [
+			{"id": "1", "type": "TXT", "name": "_acme-challenge.a", "value": "value", "ttl": "1m0s"}
+		]}]`, rec.Body.String())
+	}
+}
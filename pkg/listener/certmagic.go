@@ -0,0 +1,119 @@
+package listener
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/mholt/acmez/acme"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/config"
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns"
+)
+
+var certMagicCAs = map[string]string{
+	"":           certmagic.LetsEncryptProductionCA,
+	"production": certmagic.LetsEncryptProductionCA,
+	"staging":    certmagic.LetsEncryptStagingCA,
+}
+
+var certMagicKeyTypes = map[string]certmagic.KeyType{
+	"":        certmagic.P256,
+	"ed25519": certmagic.ED25519,
+	"p256":    certmagic.P256,
+	"p384":    certmagic.P384,
+	"rsa2048": certmagic.RSA2048,
+	"rsa4096": certmagic.RSA4096,
+	"rsa8192": certmagic.RSA8192,
+}
+
+// CertMagic manages the certificates of a listener with its own certmagic
+// config and cache, leaving certmagic's package defaults untouched.
+type CertMagic struct {
+	hosts  []string
+	cache  *certmagic.Cache
+	config *certmagic.Config
+}
+
+// NewCertMagic creates a CertMagic solving DNS-01 challenges with provider.
+// Stop must be called to release the certificate cache.
+func NewCertMagic(cfg *config.CertMagic, provider dns.Provider) (*CertMagic, error) {
+	keyType, ok := certMagicKeyTypes[cfg.KeyType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported certmagic key_type %q", cfg.KeyType)
+	}
+	ca, ok := certMagicCAs[cfg.CA]
+	if !ok {
+		ca = cfg.CA
+	}
+
+	issuer := certmagic.ACMEIssuer{
+		CA:     ca,
+		Email:  cfg.Email,
+		Agreed: true,
+		DNS01Solver: &certmagic.DNS01Solver{
+			DNSProvider: provider.Underlying(),
+		},
+		DisableHTTPChallenge:    true,
+		DisableTLSALPNChallenge: true,
+	}
+	if cfg.ExternalAccount != nil {
+		issuer.ExternalAccount = &acme.EAB{
+			KeyID:  cfg.ExternalAccount.KeyID,
+			MACKey: cfg.ExternalAccount.MACKey,
+		}
+	}
+	if cfg.CARootFile != "" {
+		pem, err := os.ReadFile(cfg.CARootFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading certmagic ca_root_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CARootFile)
+		}
+		issuer.TrustedRoots = pool
+	}
+
+	cmTemplate := certmagic.Config{
+		KeySource: certmagic.StandardKeyGenerator{KeyType: keyType},
+	}
+	if cfg.Storage != "" {
+		cmTemplate.Storage = &certmagic.FileStorage{Path: cfg.Storage}
+	}
+
+	var cmConfig *certmagic.Config
+	cache := certmagic.NewCache(certmagic.CacheOptions{
+		GetConfigForCert: func(certmagic.Certificate) (*certmagic.Config, error) {
+			return cmConfig, nil
+		},
+	})
+	cmConfig = certmagic.New(cache, cmTemplate)
+	cmConfig.Issuers = []certmagic.Issuer{certmagic.NewACMEIssuer(cmConfig, issuer)}
+
+	return &CertMagic{
+		hosts:  cfg.AllHosts(),
+		cache:  cache,
+		config: cmConfig,
+	}, nil
+}
+
+// Manage obtains or loads certificates for all hosts and keeps them renewed.
+func (c *CertMagic) Manage(ctx context.Context) error {
+	return c.config.ManageSync(ctx, c.hosts)
+}
+
+// TLSConfig returns a tls.Config serving the managed certificates.
+func (c *CertMagic) TLSConfig() *tls.Config {
+	tlsConfig := c.config.TLSConfig()
+	tlsConfig.NextProtos = append([]string{"h2", "http/1.1"}, tlsConfig.NextProtos...)
+	return tlsConfig
+}
+
+// Stop stops certificate maintenance.
+func (c *CertMagic) Stop() {
+	c.cache.Stop()
+}
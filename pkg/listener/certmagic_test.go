@@ -0,0 +1,42 @@
+package listener
+
+import (
+	"testing"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/config"
+	"github.com/hpidcock/acme-dns-proxy/pkg/dns"
+)
+
+func TestNewCertMagic(t *testing.T) {
+	provider, err := dns.NewProvider(nil, nil, nil)
+	assert.NoError(t, err)
+
+	cm, err := NewCertMagic(&config.CertMagic{
+		Host:    "acme.domain.example",
+		Hosts:   []string{"acme2.domain.example"},
+		CA:      "staging",
+		Email:   "admin@domain.example",
+		KeyType: "rsa2048",
+		Storage: t.TempDir(),
+		ExternalAccount: &config.EAB{
+			KeyID:  "kid",
+			MACKey: "mac",
+		},
+	}, provider)
+	assert.NoError(t, err)
+	defer cm.Stop()
+
+	assert.Equal(t, []string{"acme.domain.example", "acme2.domain.example"}, cm.hosts)
+	issuer := cm.config.Issuers[0].(*certmagic.ACMEIssuer)
+	assert.Equal(t, certmagic.LetsEncryptStagingCA, issuer.CA)
+	assert.Equal(t, "kid", issuer.ExternalAccount.KeyID)
+	assert.Equal(t, certmagic.StandardKeyGenerator{KeyType: certmagic.RSA2048}, cm.config.KeySource)
+	// The package defaults must be left alone.
+	assert.Nil(t, certmagic.DefaultACME.DNS01Solver)
+
+	_, err = NewCertMagic(&config.CertMagic{Host: "acme.domain.example", KeyType: "dsa"}, provider)
+	assert.ErrorContains(t, err, `unsupported certmagic key_type "dsa"`)
+}
@@ -7,13 +7,24 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/hpidcock/acme-dns-proxy/pkg/client"
 	"github.com/hpidcock/acme-dns-proxy/pkg/dns"
 	"github.com/hpidcock/acme-dns-proxy/pkg/dns01"
 	"github.com/hpidcock/acme-dns-proxy/pkg/proxy"
 )
 
-func newHTTPHandler(p proxy.Proxy) http.HandlerFunc {
+const (
+	// AuthBasic authenticates with a basic auth header. The token is the
+	// hex encoded sha256 of "username:password".
+	AuthBasic = "basic"
+	// AuthBearer authenticates with a bearer token. The token is the hex
+	// encoded sha256 of the bearer token.
+	AuthBearer = "bearer"
+)
+
+func newHTTPHandler(p proxy.Proxy, auth []string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		default:
@@ -38,7 +49,7 @@ func newHTTPHandler(p proxy.Proxy) http.HandlerFunc {
 			return
 		}
 
-		req, err := parseHTTPRequest(r)
+		req, err := parseHTTPRequest(r, auth)
 		if err != nil {
 			p.Log.Errorf("bad request: %s %s %s", r.Method, r.URL.String(), err.Error())
 			badRequest(w, err)
@@ -48,6 +59,7 @@ func newHTTPHandler(p proxy.Proxy) http.HandlerFunc {
 		req.Action = action
 
 		err = p.Handle(r.Context(), req)
+		w.Header().Set(client.RequestIDHeader, req.ID)
 		if err != nil {
 			// We dont want to expose information to unauthorized clients.
 			// So we dont care about the reason and always respond with unauthorized.
@@ -72,7 +84,7 @@ func newHTTPHandler(p proxy.Proxy) http.HandlerFunc {
 	}
 }
 
-func parseHTTPRequest(httpReq *http.Request) (*proxy.Request, error) {
+func parseHTTPRequest(httpReq *http.Request, auth []string) (*proxy.Request, error) {
 	payload := map[string]string{}
 
 	err := json.NewDecoder(httpReq.Body).Decode(&payload)
@@ -90,15 +102,25 @@ func parseHTTPRequest(httpReq *http.Request) (*proxy.Request, error) {
 		keyAuth = dns01.EncodeKeyAuthorization(payload["keyAuth"])
 	}
 
-	token, err := convertBasicAuthToToken(httpReq)
+	var lifetime time.Duration
+	if payload["lifetime"] != "" {
+		lifetime, err = time.ParseDuration(payload["lifetime"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid lifetime: %w", err)
+		}
+	}
+
+	token, err := authToken(httpReq, auth)
 	if err != nil {
 		return nil, err
 	}
 
 	req := proxy.Request{
+		ID: requestID(httpReq),
 		Challenge: dns.Challenge{
 			FQDN:           dns01.ToFQDN(fqdn),
 			EncodedKeyAuth: keyAuth,
+			Lifetime:       lifetime,
 		},
 		Remote: proxy.Remote{
 			Address: httpReq.RemoteAddr,
@@ -110,15 +132,51 @@ func parseHTTPRequest(httpReq *http.Request) (*proxy.Request, error) {
 	return &req, nil
 }
 
-func convertBasicAuthToToken(req *http.Request) (string, error) {
-	username, password, ok := req.BasicAuth()
-	if !ok {
-		return "", fmt.Errorf("invalid basic auth header")
+// authToken returns the token for the first of the auth modes the request
+// carries credentials for.
+func authToken(req *http.Request, auth []string) (string, error) {
+	for _, mode := range auth {
+		switch mode {
+		case AuthBasic:
+			if username, password, ok := req.BasicAuth(); ok {
+				return hashToken(fmt.Sprintf("%s:%s", username, password)), nil
+			}
+		case AuthBearer:
+			if token, ok := bearerAuth(req); ok {
+				return hashToken(token), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("invalid auth header")
+}
+
+func bearerAuth(req *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	header := req.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
 	}
+	return header[len(prefix):], true
+}
 
-	in := []byte(fmt.Sprintf("%s:%s", username, password))
-	hash := sha256.Sum256(in)
-	return hex.EncodeToString(hash[:]), nil
+func hashToken(s string) string {
+	hash := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(hash[:])
+}
+
+// requestID returns the request ID sent by the client, such as a
+// downstream acmep server, or an empty string if it is missing or unusable.
+func requestID(req *http.Request) string {
+	id := req.Header.Get(client.RequestIDHeader)
+	if len(id) > 128 {
+		return ""
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return ""
+		}
+	}
+	return id
 }
 
 func isLegoRawRequest(data map[string]string) bool {
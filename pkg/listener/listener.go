@@ -2,29 +2,94 @@ package listener
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/http"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/hpidcock/acme-dns-proxy/pkg/config"
 	"github.com/hpidcock/acme-dns-proxy/pkg/proxy"
 )
 
-// Serve accepts connections and handles requests.
-func Serve(ctx context.Context, log *logrus.Logger, defaultServer http.Server, p proxy.Proxy, done chan any) {
+const (
+	// ProtocolHTTPReq serves the lego httpreq API.
+	ProtocolHTTPReq = "httpreq"
+)
+
+// NewServer creates a http.Server from a listener config serving p.
+// reload is called when the admin API requests a config reload. TLS is
+// left to the caller.
+func NewServer(cfg config.Listener, p proxy.Proxy, reload func()) (*http.Server, error) {
+	server := &http.Server{
+		Addr: cfg.Address,
+	}
+
+	timeouts := []struct {
+		value string
+		field *time.Duration
+	}{
+		{cfg.ReadTimeout, &server.ReadTimeout},
+		{cfg.ReadHeaderTimeout, &server.ReadHeaderTimeout},
+		{cfg.WriteTimeout, &server.WriteTimeout},
+		{cfg.IdleTimeout, &server.IdleTimeout},
+	}
+	for _, t := range timeouts {
+		if t.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(t.value)
+		if err != nil {
+			return nil, fmt.Errorf("listener %q: invalid timeout: %w", cfg.Name, err)
+		}
+		*t.field = d
+	}
+
+	auth := cfg.Auth
+	if len(auth) == 0 {
+		auth = []string{AuthBasic}
+	}
+	for _, mode := range auth {
+		if mode != AuthBasic && mode != AuthBearer {
+			return nil, fmt.Errorf("listener %q: unsupported auth mode %q", cfg.Name, mode)
+		}
+	}
+
+	switch cfg.Protocol {
+	case "", ProtocolHTTPReq:
+		server.Handler = newHTTPHandler(p, auth)
+	case ProtocolAdmin:
+		if len(cfg.AdminTokens) == 0 {
+			return nil, fmt.Errorf("listener %q: admin_tokens not set", cfg.Name)
+		}
+		server.Handler = newAdminHandler(p, auth, cfg.AdminTokens, reload)
+	default:
+		return nil, fmt.Errorf("listener %q: unsupported protocol %q", cfg.Name, cfg.Protocol)
+	}
+
+	return server, nil
+}
+
+// Serve accepts connections and handles requests until ctx is done.
+func Serve(ctx context.Context, log *logrus.Logger, server *http.Server, done chan any) {
 	defer close(done)
 	var err error
-	server := &defaultServer
-	server.Handler = newHTTPHandler(p)
 	server.BaseContext = func(l net.Listener) context.Context {
 		return ctx
 	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
 	if server.TLSConfig != nil {
 		err = server.ListenAndServeTLS("", "")
 	} else {
 		err = server.ListenAndServe()
 	}
-	if err != nil {
+	if err != nil && err != http.ErrServerClosed {
 		log.Error(err)
 	}
 }
@@ -0,0 +1,142 @@
+package listener
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/config"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes.
+const certCheckInterval = 10 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig creates a tls.Config serving the certificate in cfg. The
+// certificate is reloaded when its files change, the client CAs are only
+// loaded once.
+func NewTLSConfig(log *logrus.Logger, cfg *config.TLS) (*tls.Config, error) {
+	minVersion := uint16(tls.VersionTLS12)
+	if cfg.MinVersion != "" {
+		v, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls min_version %q", cfg.MinVersion)
+		}
+		minVersion = v
+	}
+
+	reloader := &certReloader{
+		log:      log,
+		certFile: cfg.CertFile,
+		keyFile:  cfg.KeyFile,
+	}
+	err := reloader.load()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CAs: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// certReloader serves a certificate from disk, reloading it when the
+// modification time of either file changes.
+type certReloader struct {
+	log      *logrus.Logger
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) < certCheckInterval {
+		return r.cert, nil
+	}
+	r.checked = time.Now()
+	modTime, err := r.latestModTime()
+	if err != nil {
+		r.log.Errorf("checking certificate %s: %v", r.certFile, err)
+		return r.cert, nil
+	}
+	if modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+	err = r.loadLocked()
+	if err != nil {
+		r.log.Errorf("reloading certificate %s: %v", r.certFile, err)
+		return r.cert, nil
+	}
+	r.log.Infof("reloaded certificate %s", r.certFile)
+	return r.cert, nil
+}
+
+func (r *certReloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadLocked()
+}
+
+func (r *certReloader) loadLocked() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	r.checked = time.Now()
+	return nil
+}
+
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,105 @@
+package listener
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/hpidcock/acme-dns-proxy/pkg/config"
+)
+
+func writeCert(t *testing.T, certFile, keyFile, cn string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	assert.NoError(t, err)
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	assert.NoError(t, err)
+}
+
+func commonName(t *testing.T, reloader *certReloader) string {
+	cert, err := reloader.GetCertificate(nil)
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "acme.domain.example")
+
+	tlsConfig, err := NewTLSConfig(logrus.New(), &config.TLS{
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		MinVersion: "1.3",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+	cert, err := tlsConfig.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, cert)
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "first")
+
+	reloader := &certReloader{log: logrus.New(), certFile: certFile, keyFile: keyFile}
+	assert.NoError(t, reloader.load())
+	assert.Equal(t, "first", commonName(t, reloader))
+
+	writeCert(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, later, later))
+
+	// Within the check interval the old certificate is served.
+	assert.Equal(t, "first", commonName(t, reloader))
+
+	reloader.checked = time.Time{}
+	assert.Equal(t, "second", commonName(t, reloader))
+
+	// A broken file keeps the last good certificate.
+	assert.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0600))
+	later = later.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, later, later))
+	reloader.checked = time.Time{}
+	assert.Equal(t, "second", commonName(t, reloader))
+}
+
+func TestNewTLSConfigInvalid(t *testing.T) {
+	_, err := NewTLSConfig(logrus.New(), &config.TLS{
+		CertFile: "missing.pem",
+		KeyFile:  "missing.pem",
+	})
+	assert.Error(t, err)
+
+	_, err = NewTLSConfig(logrus.New(), &config.TLS{MinVersion: "1.4"})
+	assert.ErrorContains(t, err, `unsupported tls min_version "1.4"`)
+}
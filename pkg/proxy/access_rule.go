@@ -3,16 +3,22 @@ package proxy
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/juju/errors"
 
 	"github.com/hpidcock/acme-dns-proxy/pkg/config"
 )
 
+// DefaultMaxLifetime is the challenge lifetime used when an ACL does not
+// configure one.
+const DefaultMaxLifetime = time.Hour
+
 // ACL defines a pattern and the corresponding auth key
 type ACL struct {
-	Pattern Pattern
-	Token   string
+	Pattern     Pattern
+	Token       string
+	MaxLifetime time.Duration
 }
 
 // NewACLsFromConfig creates ACLs from configuration
@@ -31,9 +37,18 @@ func NewACLsFromConfig(cfg []config.ACL) (ACLs, error) {
 			return ACL{}, fmt.Errorf("'token' not specified")
 		}
 
+		maxLifetime := DefaultMaxLifetime
+		if ruleCfg.MaxLifetime != "" {
+			maxLifetime, err = time.ParseDuration(ruleCfg.MaxLifetime)
+			if err != nil {
+				return ACL{}, fmt.Errorf("invalid max_lifetime: %w", err)
+			}
+		}
+
 		return ACL{
-			Pattern: pattern,
-			Token:   ruleCfg.Token,
+			Pattern:     pattern,
+			Token:       ruleCfg.Token,
+			MaxLifetime: maxLifetime,
 		}, nil
 	}
 
@@ -54,9 +69,42 @@ func (a *ACL) CheckAuth(token string) bool {
 	return token == a.Token
 }
 
+// Lifetime returns the lifetime for a challenge record given the lifetime
+// requested by the client, which is capped at MaxLifetime. A request of
+// zero gets MaxLifetime.
+func (a *ACL) Lifetime(requested time.Duration) time.Duration {
+	if requested <= 0 || requested > a.MaxLifetime {
+		return a.MaxLifetime
+	}
+	return requested
+}
+
 // ACLs is a list of ACLs
 type ACLs []ACL
 
+// Select returns the ACLs with the given patterns, in the order given. All
+// ACLs are returned if patterns is empty.
+func (a ACLs) Select(patterns []string) (ACLs, error) {
+	if len(patterns) == 0 {
+		return a, nil
+	}
+	rules := ACLs{}
+	for _, pattern := range patterns {
+		found := false
+		for i := range a {
+			if a[i].Pattern.String() == pattern {
+				rules = append(rules, a[i])
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.NotFoundf("acl %q", pattern)
+		}
+	}
+	return rules, nil
+}
+
 // Search for a access rule by FQDN
 func (a ACLs) Search(fqdn string) (ACL, error) {
 	for _, rule := range a {
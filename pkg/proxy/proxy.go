@@ -20,8 +20,12 @@ type Proxy struct {
 
 // Handle validates and authenticates a request. If everything is fine, the configured DNS provider API gets called.
 func (p *Proxy) Handle(ctx context.Context, req *Request) error {
+	if req.ID == "" {
+		req.ID = uuid.New().String()
+	}
+	ctx = dns.ContextWithRequestID(ctx, req.ID)
 	log := p.Log.
-		WithField("reqID", uuid.New().String()).
+		WithField("reqID", req.ID).
 		WithField("action", req.Action)
 
 	log.Info("request",
@@ -42,6 +46,8 @@ func (p *Proxy) Handle(ctx context.Context, req *Request) error {
 	if !rule.CheckAuth(req.AuthToken) {
 		return fmt.Errorf("access denied")
 	}
+	req.Challenge.Principal = rule.Pattern.String()
+	req.Challenge.Lifetime = rule.Lifetime(req.Challenge.Lifetime)
 
 	switch req.Action {
 	case "present":
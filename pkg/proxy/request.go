@@ -6,6 +6,7 @@ import (
 
 // Request holds information about the request.
 type Request struct {
+	ID        string        // ID for the current request, used in logs and passed on to upstream acmep servers
 	Action    string        // Action for the current request. Can be present or cleanup
 	AuthToken string        // AuthToken for the current request
 	Challenge dns.Challenge // Challenge for the current request